@@ -0,0 +1,103 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/pkg/errors"
+)
+
+// Returns the Application Auto Scaling target registered for the service's
+// desired count, or nil if there is none.
+func (d *DownScaler) describeScalableTarget(ctx context.Context) (*applicationautoscaling.ScalableTarget, error) {
+	out, err := d.aas.DescribeScalableTargetsWithContext(ctx, &applicationautoscaling.DescribeScalableTargetsInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceEcs),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionEcsServiceDesiredCount),
+		ResourceIds:       []*string{aws.String(d.scalableTargetResourceID())},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot describe scalable targets")
+	}
+	for _, target := range out.ScalableTargets {
+		return target, nil
+	}
+	return nil, nil
+}
+
+func (d *DownScaler) scalableTargetResourceID() string {
+	return fmt.Sprintf("service/%s/%s", d.Cluster, d.Service)
+}
+
+// Sets the suspended state of the service's scalable target.
+func (d *DownScaler) setScalableTargetSuspendedState(ctx context.Context, state *applicationautoscaling.SuspendedState) error {
+	_, err := d.aas.RegisterScalableTargetWithContext(ctx, &applicationautoscaling.RegisterScalableTargetInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceEcs),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionEcsServiceDesiredCount),
+		ResourceId:        aws.String(d.scalableTargetResourceID()),
+		SuspendedState:    state,
+	})
+	return errors.Wrap(err, "cannot update scalable target")
+}
+
+// Reports whether the service's scalable target is to be suspended for the
+// run, under either of the option's names.
+func (c *Config) suspendsScalableTarget() bool {
+	return c.SuspendScalableTarget || c.DeregisterScalableTarget
+}
+
+// Guards against Application Auto Scaling undoing the scale-down. If the
+// service has a scalable target, its scaling activities are suspended when
+// SuspendScalableTarget is set and the run aborts otherwise. Dry runs check
+// the same, but leave the target alone. The returned function restores the
+// target's original state and must be called once the run is over, however
+// it ends.
+func (d *DownScaler) suspendScalableTarget(ctx context.Context) (func() error, error) {
+	noop := func() error { return nil }
+
+	target, err := d.describeScalableTarget(ctx)
+	if err != nil {
+		return noop, err
+	}
+	if target == nil {
+		return noop, nil
+	}
+	if !d.suspendsScalableTarget() {
+		return noop, fmt.Errorf("service %s is managed by Application Auto Scaling (min %d, max %d), which would undo the scale-down; use -suspend-scalable-target to suspend its scaling during the run",
+			d.Service, aws.Int64Value(target.MinCapacity), aws.Int64Value(target.MaxCapacity))
+	}
+
+	if min := aws.Int64Value(target.MinCapacity); d.DesiredCount < min {
+		d.warnf("desired count %d is below the minimum capacity %d of service %s's scalable target, which will scale it back up once its scaling is resumed; lower the target's minimum capacity to keep the scale-down", d.DesiredCount, min, d.Service)
+	}
+
+	if d.DryRun {
+		log.Printf("Would suspend the scaling of service %s's scalable target during the run", d.Service)
+		return noop, nil
+	}
+
+	original := target.SuspendedState
+	if original == nil {
+		original = &applicationautoscaling.SuspendedState{}
+	}
+	restore := &applicationautoscaling.SuspendedState{
+		DynamicScalingInSuspended:  aws.Bool(aws.BoolValue(original.DynamicScalingInSuspended)),
+		DynamicScalingOutSuspended: aws.Bool(aws.BoolValue(original.DynamicScalingOutSuspended)),
+		ScheduledScalingSuspended:  aws.Bool(aws.BoolValue(original.ScheduledScalingSuspended)),
+	}
+
+	err = d.setScalableTargetSuspendedState(ctx, &applicationautoscaling.SuspendedState{
+		DynamicScalingInSuspended:  aws.Bool(true),
+		DynamicScalingOutSuspended: aws.Bool(true),
+		ScheduledScalingSuspended:  aws.Bool(true),
+	})
+	if err != nil {
+		return noop, err
+	}
+	return func() error {
+		// Even if the run was cancelled.
+		return d.setScalableTargetSuspendedState(context.Background(), restore)
+	}, nil
+}
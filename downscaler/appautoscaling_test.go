@@ -0,0 +1,101 @@
+package downscaler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+)
+
+func TestSuspendScalableTargetBelowMinCapacity(t *testing.T) {
+	tests := []struct {
+		name         string
+		desiredCount int64
+		wantWarning  bool
+	}{
+		{name: "at the minimum capacity", desiredCount: 2},
+		{name: "below the minimum capacity", desiredCount: 1, wantWarning: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{Cluster: "cluster", Service: "service", DesiredCount: test.desiredCount, SuspendScalableTarget: true}
+			d, _ := newTestDownScaler(t, config, map[string]fakeHandler{
+				"DescribeScalableTargets": func(interface{}) (interface{}, error) {
+					return &applicationautoscaling.DescribeScalableTargetsOutput{
+						ScalableTargets: []*applicationautoscaling.ScalableTarget{{MinCapacity: aws.Int64(2), MaxCapacity: aws.Int64(10)}},
+					}, nil
+				},
+				"RegisterScalableTarget": func(interface{}) (interface{}, error) {
+					return &applicationautoscaling.RegisterScalableTargetOutput{}, nil
+				},
+			})
+
+			if _, err := d.suspendScalableTarget(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			warned := len(d.result.Warnings) == 1 && strings.Contains(d.result.Warnings[0], "minimum capacity 2")
+			if warned != test.wantWarning || len(d.result.Warnings) > 1 {
+				t.Errorf("warnings %q; want a minimum capacity warning %t", d.result.Warnings, test.wantWarning)
+			}
+		})
+	}
+}
+
+func TestSuspendScalableTargetDryRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "refused", wantErr: true},
+		{name: "suspended", config: Config{SuspendScalableTarget: true}},
+		{name: "deregistered, the alias", config: Config{DeregisterScalableTarget: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Cluster, config.Service, config.DesiredCount, config.DryRun = "cluster", "service", 2, true
+			d, fake := newTestDownScaler(t, &config, map[string]fakeHandler{
+				"DescribeScalableTargets": func(interface{}) (interface{}, error) {
+					return &applicationautoscaling.DescribeScalableTargetsOutput{
+						ScalableTargets: []*applicationautoscaling.ScalableTarget{{MinCapacity: aws.Int64(1), MaxCapacity: aws.Int64(10)}},
+					}, nil
+				},
+			})
+
+			restore, err := d.suspendScalableTarget(context.Background())
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v; want one %t", err, test.wantErr)
+			}
+			if err := restore(); err != nil {
+				t.Fatal(err)
+			}
+			if registered := len(fake.inputs("RegisterScalableTarget")); registered > 0 {
+				t.Errorf("dry run registered the scalable target %d times", registered)
+			}
+		})
+	}
+}
+
+// A dry run fails where the real run would.
+func TestDryRunRefusesScalableTarget(t *testing.T) {
+	cluster := newFakeCluster(4)
+	config := cluster.config()
+	config.DesiredCount = 2
+	config.DryRun = true
+	handlers := cluster.handlers()
+	handlers["DescribeScalableTargets"] = func(interface{}) (interface{}, error) {
+		return &applicationautoscaling.DescribeScalableTargetsOutput{
+			ScalableTargets: []*applicationautoscaling.ScalableTarget{{MinCapacity: aws.Int64(1), MaxCapacity: aws.Int64(10)}},
+		}, nil
+	}
+	d, _ := newTestDownScaler(t, config, handlers)
+
+	if _, err := d.Run(); err == nil || !strings.Contains(err.Error(), "managed by Application Auto Scaling") {
+		t.Errorf("got error %v; want the service refused", err)
+	}
+}
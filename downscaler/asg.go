@@ -2,7 +2,13 @@ package downscaler
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
@@ -23,37 +29,316 @@ func (d *DownScaler) updateASG(ctx context.Context, minSize int64, shouldSetMaxS
 	if _, err := d.asg.UpdateAutoScalingGroupWithContext(ctx, input); err != nil {
 		return err
 	}
+	if d.SkipStableWait {
+		return nil
+	}
 
 	return d.asg.WaitUntilGroupInServiceWithContext(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{&d.ASG},
 	})
 }
 
+// Lowers only the ASG's minimum size, leaving its desired capacity to be
+// decremented by terminating instances.
+func (d *DownScaler) updateASGMinSize(ctx context.Context, minSize int64) error {
+	_, err := d.asg.UpdateAutoScalingGroupWithContext(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: &d.ASG,
+		MinSize:              &minSize,
+	})
+	return errors.Wrap(err, "cannot update ASG minimum size")
+}
+
 func (d *DownScaler) terminateContainerInstances(ctx context.Context, containerInstances []*ecs.ContainerInstance) error {
 	instanceIDs := make([]*string, 0, len(containerInstances))
 
-	decrementDesiredCapacity := false
+	arns := make([]*string, 0, len(containerInstances))
 	for _, ci := range containerInstances {
+		arns = append(arns, ci.ContainerInstanceArn)
+	}
+	// Only used to report on the terminated instances, so don't let it stop the termination.
+	details, err := d.describeContainerEC2Instances(ctx, arns)
+	if err != nil {
+		d.warnf("%s", err)
+	}
+
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return err
+	}
+	inASG := make(map[string]bool)
+	for _, instance := range group.Instances {
+		inASG[aws.StringValue(instance.InstanceId)] = true
+	}
+	if !d.TerminateOrphansWithEC2 {
+		// Fail before terminating anything rather than half way through.
+		for _, ci := range containerInstances {
+			if !inASG[*ci.Ec2InstanceId] && !d.state.isTerminated(*ci.Ec2InstanceId) {
+				return fmt.Errorf("%s isn't in ASG %s, so it can't be terminated through it; use -terminate-orphans to terminate it through EC2", *ci.Ec2InstanceId, d.ASG)
+			}
+		}
+	}
+
+	// In flip mode the ASG should replace what we terminate.
+	decrementDesiredCapacity := d.DecrementOnTerminate && !d.InstanceFlip
+	for _, ci := range containerInstances {
+		if d.state.isTerminated(*ci.Ec2InstanceId) {
+			log.Printf("%s was already terminated by a previous run; skipping", *ci.Ec2InstanceId)
+			d.result.Skipped = append(d.result.Skipped, SkippedInstance{ContainerInstanceArn: aws.StringValue(ci.ContainerInstanceArn), Reason: SkipAlreadyTerminated})
+			continue
+		}
 		instanceIDs = append(instanceIDs, ci.Ec2InstanceId)
+		if len(d.TagBeforeTerminate) > 0 {
+			d.tagBeforeTerminate(ctx, *ci.Ec2InstanceId)
+		}
+
+		if !inASG[*ci.Ec2InstanceId] {
+			log.Printf("%s isn't in ASG %s; terminating it through EC2", *ci.Ec2InstanceId, d.ASG)
+			_, err := d.ec2.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+				InstanceIds: []*string{ci.Ec2InstanceId},
+			})
+			if err != nil {
+				return errors.Wrapf(err, "cannot terminate %s", *ci.Ec2InstanceId)
+			}
+		} else {
+			input := &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+				InstanceId:                     ci.Ec2InstanceId,
+				ShouldDecrementDesiredCapacity: &decrementDesiredCapacity,
+			}
+			_, err := d.asg.TerminateInstanceInAutoScalingGroupWithContext(ctx, input)
+			if err != nil {
+				return err
+			}
+		}
+		d.result.Terminated = append(d.result.Terminated, *ci.Ec2InstanceId)
+		d.result.Instances = append(d.result.Instances, d.terminatedInstance(ci, details[aws.StringValue(ci.ContainerInstanceArn)]))
+		if d.Record {
+			continue
+		}
+		if err := d.state.markTerminated(*ci.Ec2InstanceId, aws.StringValue(ci.ContainerInstanceArn)); err != nil {
+			return err
+		}
+	}
+	// Record runs didn't really terminate anything to wait for.
+	if len(instanceIDs) == 0 || d.Record || d.SkipStableWait {
+		return nil
+	}
+
+	waitCtx := ctx
+	if d.TerminateTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, d.TerminateTimeout)
+		defer cancel()
+	}
+	err = d.waitUntilTerminated(waitCtx, instanceIDs)
+	if err != nil {
+		if waitCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("instances not terminated after %s: %s", d.TerminateTimeout, strings.Join(aws.StringValueSlice(instanceIDs), ", "))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// How often to check the ASG's sizes while waiting for them to settle.
+const asgSettlePollInterval = 10 * time.Second
+
+// Checks the ASG's sizes match those expected, waiting up to VerifyASGSettle
+// for them to, in case e.g. a scaling activity was still finishing.
+func (d *DownScaler) verifyASGSizes(ctx context.Context, expected ASGSizes) error {
+	d.result.ExpectedASGSizes = &expected
+	deadline := time.Now().Add(d.VerifyASGSettle)
+	for {
+		group, err := d.describeASG(ctx)
+		if err != nil {
+			return err
+		}
+		observed := ASGSizes{
+			DesiredCapacity: aws.Int64Value(group.DesiredCapacity),
+			MinSize:         aws.Int64Value(group.MinSize),
+			MaxSize:         aws.Int64Value(group.MaxSize),
+		}
+		d.result.ObservedASGSizes = &observed
+		if observed == expected {
+			log.Printf("ASG %s settled at desired %d, min %d, max %d", d.ASG, observed.DesiredCapacity, observed.MinSize, observed.MaxSize)
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("ASG %s was changed during the run: desired %d, min %d, max %d, expected desired %d, min %d, max %d",
+				d.ASG, observed.DesiredCapacity, observed.MinSize, observed.MaxSize, expected.DesiredCapacity, expected.MinSize, expected.MaxSize)
+		}
+		log.Printf("Waiting for ASG %s to settle at desired %d, min %d, max %d...", d.ASG, expected.DesiredCapacity, expected.MinSize, expected.MaxSize)
+		if err := sleepContext(ctx, asgSettlePollInterval); err != nil {
+			return err
+		}
+	}
+}
 
-		input := &autoscaling.TerminateInstanceInAutoScalingGroupInput{
-			InstanceId:                     ci.Ec2InstanceId,
-			ShouldDecrementDesiredCapacity: &decrementDesiredCapacity,
+// Returns the ASG's scaling activities that haven't finished yet.
+func (d *DownScaler) inProgressScalingActivities(ctx context.Context) ([]*autoscaling.Activity, error) {
+	// Activities come newest first, so unfinished ones are on the first page.
+	out, err := d.asg.DescribeScalingActivitiesWithContext(ctx, &autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: &d.ASG,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot describe scaling activities")
+	}
+	var activities []*autoscaling.Activity
+	for _, activity := range out.Activities {
+		switch aws.StringValue(activity.StatusCode) {
+		case autoscaling.ScalingActivityStatusCodeSuccessful, autoscaling.ScalingActivityStatusCodeFailed, autoscaling.ScalingActivityStatusCodeCancelled:
+		default:
+			activities = append(activities, activity)
 		}
-		_, err := d.asg.TerminateInstanceInAutoScalingGroupWithContext(ctx, input)
+	}
+	return activities, nil
+}
+
+// Checks the ASG has no scaling activity in progress, so our changes aren't
+// layered on top of its own. Waits up to WaitForASGSettle for activities to
+// finish, and fails if they don't, or straight away if it's zero.
+func (d *DownScaler) checkScalingActivities(ctx context.Context) error {
+	deadline := time.Now().Add(d.WaitForASGSettle)
+	for {
+		activities, err := d.inProgressScalingActivities(ctx)
 		if err != nil {
 			return err
 		}
+		if len(activities) == 0 {
+			return nil
+		}
+		descriptions := make([]string, len(activities))
+		for i, activity := range activities {
+			descriptions[i] = fmt.Sprintf("%s (%s)", aws.StringValue(activity.Description), aws.StringValue(activity.StatusCode))
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("ASG %s has %d scaling activities in progress; wait for them to finish, or set -wait-for-asg-settle:\n\t%s", d.ASG, len(activities), strings.Join(descriptions, "\n\t"))
+		}
+		log.Printf("Waiting for %d scaling activities of ASG %s to finish: %s", len(activities), d.ASG, strings.Join(descriptions, "; "))
+		if err := sleepContext(ctx, asgSettlePollInterval); err != nil {
+			return err
+		}
 	}
+}
+
+// ReasonTag is the tag TagBeforeTerminate adds with the run's Reason.
+const ReasonTag = "ecs-down:reason"
 
+// Tags the instance with TagBeforeTerminate, and ReasonTag if the run has a
+// Reason, warning if it can't.
+func (d *DownScaler) tagBeforeTerminate(ctx context.Context, instanceID string) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var tags []*ec2.Tag
+	for key, value := range d.TagBeforeTerminate {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(strings.Replace(value, "{now}", now, -1)),
+		})
+	}
+	if d.Reason != "" {
+		// Tag values are at most 256 characters.
+		reason := truncate(d.Reason, 256)
+		tags = append(tags, &ec2.Tag{Key: aws.String(ReasonTag), Value: &reason})
+	}
+	_, err := d.ec2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{&instanceID},
+		Tags:      tags,
+	})
+	if err != nil {
+		d.warnf("cannot tag %s before terminating it: %s", instanceID, err)
+	}
+}
+
+// How often, and how many times, polling terminated instances backs off when
+// EC2 throttles it.
+const (
+	throttleBackoff    = 5 * time.Second
+	maxThrottleBackoff = 2 * time.Minute
+	maxThrottleRetries = 10
+)
+
+// Waits until the given instances are terminated. On big accounts the
+// waiter's DescribeInstances polls can be throttled past the SDK's retries,
+// so if the waiter fails that way this carries on polling by itself, backing
+// off while throttled.
+func (d *DownScaler) waitUntilTerminated(ctx context.Context, instanceIDs []*string) error {
 	err := d.ec2.WaitUntilInstanceTerminatedWithContext(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: instanceIDs,
 	})
-	if err != nil {
+	if err == nil || !request.IsErrorThrottle(err) {
 		return err
 	}
+	log.Printf("Throttled waiting for instances to terminate (%s); polling with backoff", err)
 
-	return nil
+	backoff, throttles := throttleBackoff, 0
+	for {
+		terminated := true
+		fn := func(page *ec2.DescribeInstancesOutput, hasNext bool) bool {
+			for _, res := range page.Reservations {
+				for _, instance := range res.Instances {
+					if aws.StringValue(instance.State.Name) != ec2.InstanceStateNameTerminated {
+						terminated = false
+					}
+				}
+			}
+			return page.NextToken != nil
+		}
+		err := d.ec2.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: instanceIDs}, fn)
+		wait := drainPollInterval
+		switch {
+		case err != nil && request.IsErrorThrottle(err):
+			throttles++
+			if throttles > maxThrottleRetries {
+				return errors.Wrapf(err, "still throttled after %d retries waiting for instances to terminate", maxThrottleRetries)
+			}
+			log.Printf("Throttled describing instances; retrying in %s", backoff)
+			wait = backoff
+			if backoff *= 2; backoff > maxThrottleBackoff {
+				backoff = maxThrottleBackoff
+			}
+		case err != nil:
+			return errors.Wrap(err, "cannot describe instances")
+		case terminated:
+			return nil
+		default:
+			throttles, backoff = 0, throttleBackoff
+			log.Printf("Waiting for %d instances to terminate...", len(instanceIDs))
+		}
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns those of the given container instances whose EC2 instances are in
+// the group. Without TerminateOrphansWithEC2 they all must be, or they
+// couldn't be terminated, so the group isn't checked.
+func (d *DownScaler) containerInstancesInGroup(ctx context.Context, group *autoscaling.Group, arns []*string) ([]*string, error) {
+	if !d.TerminateOrphansWithEC2 {
+		return arns, nil
+	}
+	members := make(map[string]bool)
+	for _, instance := range group.Instances {
+		members[aws.StringValue(instance.InstanceId)] = true
+	}
+	instances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+	inGroup := make(map[string]bool)
+	for _, ci := range instances {
+		if members[aws.StringValue(ci.Ec2InstanceId)] {
+			inGroup[aws.StringValue(ci.ContainerInstanceArn)] = true
+		}
+	}
+	var in []*string
+	for _, arn := range arns {
+		if inGroup[*arn] {
+			in = append(in, arn)
+		}
+	}
+	return in, nil
 }
 
 func (d *DownScaler) describeASG(ctx context.Context) (*autoscaling.Group, error) {
@@ -63,8 +348,215 @@ func (d *DownScaler) describeASG(ctx context.Context) (*autoscaling.Group, error
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot describe ASG")
 	}
+	var matches []*autoscaling.Group
 	for _, g := range result.AutoScalingGroups {
-		return g, nil
+		if aws.StringValue(g.AutoScalingGroupName) == d.ASG {
+			matches = append(matches, g)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("ASG %s not found (%d groups returned, none by that name)", d.ASG, len(result.AutoScalingGroups))
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("%d ASGs are named %s; expected exactly one", len(matches), d.ASG)
+	}
+}
+
+// Suspends the given scaling processes on the ASG so it doesn't launch
+// replacement capacity while we're scaling it down.
+func (d *DownScaler) suspendASGProcesses(ctx context.Context, processes []string) error {
+	_, err := d.asg.SuspendProcessesWithContext(ctx, &autoscaling.ScalingProcessQuery{
+		AutoScalingGroupName: &d.ASG,
+		ScalingProcesses:     aws.StringSlice(processes),
+	})
+	return errors.Wrap(err, "cannot suspend ASG processes")
+}
+
+func (d *DownScaler) resumeASGProcesses(ctx context.Context, processes []string) error {
+	_, err := d.asg.ResumeProcessesWithContext(ctx, &autoscaling.ScalingProcessQuery{
+		AutoScalingGroupName: &d.ASG,
+		ScalingProcesses:     aws.StringSlice(processes),
+	})
+	return errors.Wrap(err, "cannot resume ASG processes")
+}
+
+// Returns the number of instances the cluster should end up with.
+func (d *DownScaler) instanceTarget() int64 {
+	if d.ASGDesiredCount > 0 {
+		return d.ASGDesiredCount
+	}
+	return d.DesiredCount
+}
+
+// Returns the capacity the ASG should end up with: the instance target, plus
+// the warm pool kept in it.
+func (d *DownScaler) asgTarget() int64 {
+	return d.instanceTarget() + int64(len(d.result.WarmPool))
+}
+
+// Splits the drained instances into those kept in the warm pool, until it
+// has WarmPoolSize instances, and the rest, to be terminated.
+func (d *DownScaler) keepWarm(drained []*ecs.ContainerInstance) (warm, rest []*ecs.ContainerInstance) {
+	for _, ci := range drained {
+		if len(d.result.WarmPool) < d.WarmPoolSize {
+			log.Printf("Keeping %s running in the warm pool", aws.StringValue(ci.Ec2InstanceId))
+			d.result.WarmPool = append(d.result.WarmPool, aws.StringValue(ci.Ec2InstanceId))
+			warm = append(warm, ci)
+		} else {
+			rest = append(rest, ci)
+		}
+	}
+	return warm, rest
+}
+
+// Refuses to leave the cluster with fewer than AbsoluteMinInstances.
+func (c *Config) checkAbsoluteMinInstances(target int64) error {
+	if target < c.AbsoluteMinInstances {
+		return fmt.Errorf("refusing to scale cluster %s down to %d instances, below its floor of %d", c.Cluster, target, c.AbsoluteMinInstances)
+	}
+	return nil
+}
+
+// Reports whether the service's desired count and the ASG's desired capacity
+// are both already at their targets. Weighted and flipping runs can't tell
+// from the sizes alone, so they always go ahead.
+func (d *DownScaler) atTarget(ctx context.Context) (bool, error) {
+	if d.InstanceFlip || len(d.InstanceWeights) > 0 {
+		return false, nil
+	}
+	service, err := d.ecsService(ctx)
+	if err != nil {
+		return false, err
+	}
+	if aws.Int64Value(service.DesiredCount) != d.DesiredCount {
+		return false, nil
+	}
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return false, err
+	}
+	warm, err := d.warmInstances(ctx)
+	if err != nil {
+		return false, err
+	}
+	return aws.Int64Value(group.DesiredCapacity) == d.instanceTarget()+warm, nil
+}
+
+// Returns how many instances an earlier run kept in the warm pool: those
+// still DRAINING in the cluster, up to WarmPoolSize.
+func (d *DownScaler) warmInstances(ctx context.Context) (int64, error) {
+	if d.WarmPoolSize == 0 {
+		return 0, nil
+	}
+	var count int64
+	input := &ecs.ListContainerInstancesInput{
+		Cluster: &d.Cluster,
+		Status:  aws.String(ecs.ContainerInstanceStatusDraining),
+	}
+	fn := func(page *ecs.ListContainerInstancesOutput, isLastPage bool) bool {
+		count += int64(len(page.ContainerInstanceArns))
+		return page.NextToken != nil
+	}
+	if err := d.ecs.ListContainerInstancesPagesWithContext(ctx, input, fn); err != nil {
+		return 0, err
+	}
+	if count > int64(d.WarmPoolSize) {
+		count = int64(d.WarmPoolSize)
+	}
+	return count, nil
+}
+
+// Checks that ASGDesiredCount instances can host DesiredCount tasks, assuming
+// no instance runs more of the service's tasks than the busiest one does now.
+func (d *DownScaler) checkASGCanHostTasks(ctx context.Context) error {
+	tasks, err := d.listServiceTasks(ctx)
+	if err != nil {
+		return err
+	}
+	perInstance := make(map[string]int64)
+	var densest int64
+	for _, task := range tasks {
+		arn := aws.StringValue(task.ContainerInstanceArn)
+		perInstance[arn]++
+		if perInstance[arn] > densest {
+			densest = perInstance[arn]
+		}
+	}
+	if densest == 0 {
+		// Nothing is running, so there's nothing to go by.
+		return nil
+	}
+
+	if capacity := d.ASGDesiredCount * densest; capacity < d.DesiredCount {
+		return fmt.Errorf("%d instances can't host %d tasks: instances currently run at most %d tasks each, so %d instances fit only %d tasks",
+			d.ASGDesiredCount, d.DesiredCount, densest, d.ASGDesiredCount, capacity)
+	}
+	return nil
+}
+
+// Returns the number of weighted capacity units the given container instances
+// count for under InstanceWeights.
+func (d *DownScaler) weightedCapacity(ctx context.Context, containerArns []*string) (int64, error) {
+	instances, err := d.describeContainerEC2Instances(ctx, containerArns)
+	if err != nil {
+		return 0, err
+	}
+	var units int64
+	for _, instance := range instances {
+		units += d.instanceWeight(aws.StringValue(instance.InstanceType))
+	}
+	return units, nil
+}
+
+func (d *DownScaler) instanceWeight(instanceType string) int64 {
+	if weight, ok := d.InstanceWeights[instanceType]; ok {
+		return weight
+	}
+	d.warnf("no weight configured for instance type %s; counting it as 1 unit", instanceType)
+	return 1
+}
+
+// Returns the capacity the ASG should finally be set to. That's the instance
+// target, unless the ASG is weighted, in which case it's the weighted capacity
+// of the instances still in service.
+func (d *DownScaler) finalASGCapacity(ctx context.Context) (int64, error) {
+	if len(d.InstanceWeights) == 0 {
+		return d.asgTarget(), nil
+	}
+
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var ids []*string
+	for _, instance := range group.Instances {
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+			ids = append(ids, instance.InstanceId)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var units int64
+	fn := func(page *ec2.DescribeInstancesOutput, hasNext bool) bool {
+		for _, res := range page.Reservations {
+			for _, instance := range res.Instances {
+				units += d.instanceWeight(aws.StringValue(instance.InstanceType))
+			}
+		}
+		return page.NextToken != nil
+	}
+	for _, page := range paginateStringArray(aws.StringValueSlice(ids), 200) {
+		err := d.ec2.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice(page),
+		}, fn)
+		if err != nil {
+			return 0, errors.Wrap(err, "cannot describe instances")
+		}
 	}
-	return nil, errors.New("Could not find ASG?")
+	log.Printf("%d instances remain in service, worth %d weighted capacity units", len(ids), units)
+	return units, nil
 }
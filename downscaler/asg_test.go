@@ -0,0 +1,104 @@
+package downscaler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestDescribeASG(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  []string
+		wantErr string
+	}{
+		{
+			name:   "exact match",
+			groups: []string{"asg"},
+		},
+		{
+			name:   "exact match among others",
+			groups: []string{"asg-canary", "asg"},
+		},
+		{
+			name:    "no groups",
+			wantErr: "ASG asg not found (0 groups returned, none by that name)",
+		},
+		{
+			name:    "no group by that name",
+			groups:  []string{"asg-canary"},
+			wantErr: "ASG asg not found (1 groups returned, none by that name)",
+		},
+		{
+			name:    "several groups by that name",
+			groups:  []string{"asg", "asg"},
+			wantErr: "2 ASGs are named asg; expected exactly one",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, _ := newTestDownScaler(t, &Config{ASG: "asg"}, map[string]fakeHandler{
+				"DescribeAutoScalingGroups": func(interface{}) (interface{}, error) {
+					out := &autoscaling.DescribeAutoScalingGroupsOutput{}
+					for _, name := range test.groups {
+						out.AutoScalingGroups = append(out.AutoScalingGroups, &autoscaling.Group{AutoScalingGroupName: aws.String(name)})
+					}
+					return out, nil
+				},
+			})
+
+			group, err := d.describeASG(context.Background())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := aws.StringValue(group.AutoScalingGroupName); name != "asg" {
+				t.Errorf("got group %q; want %q", name, "asg")
+			}
+		})
+	}
+}
+
+func TestAtTargetWarmPool(t *testing.T) {
+	tests := []struct {
+		name         string
+		instances    int
+		warm         []string
+		warmPoolSize int
+		want         bool
+	}{
+		{name: "full warm pool", instances: 4, warm: []string{"i-03", "i-04"}, warmPoolSize: 2, want: true},
+		{name: "partly filled warm pool", instances: 3, warm: []string{"i-03"}, warmPoolSize: 2, want: true},
+		{name: "no warm pool yet", instances: 4, warmPoolSize: 2},
+		{name: "more draining than the warm pool", instances: 5, warm: []string{"i-03", "i-04", "i-05"}, warmPoolSize: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := newFakeCluster(test.instances)
+			cluster.desiredCount = 2
+			cluster.drained = test.warm
+			config := cluster.config()
+			config.DesiredCount = 2
+			config.WarmPoolSize = test.warmPoolSize
+			d, _ := newTestDownScaler(t, config, cluster.handlers())
+
+			got, err := d.atTarget(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("atTarget() = %t; want %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Picks drainCount of the candidates, in priority order, such that every
+// availability zone keeps at least MinPerAZ instances. Candidates that would
+// take their zone below the minimum are passed over in favour of lower
+// priority ones, as are protected ones.
+func (d *DownScaler) selectKeepingPerAZ(ctx context.Context, candidates []*string, drainCount int, protected map[string]bool) ([]*string, error) {
+	instances, err := d.describeContainerEC2Instances(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	az := func(arn string) string {
+		if instance, ok := instances[arn]; ok && instance.Placement != nil {
+			return aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+		return "unknown"
+	}
+
+	remaining := make(map[string]int)
+	for _, arn := range candidates {
+		remaining[az(*arn)]++
+	}
+
+	var selected []*string
+	for _, arn := range candidates {
+		if len(selected) == drainCount {
+			break
+		}
+		if protected[*arn] {
+			continue
+		}
+		zone := az(*arn)
+		if remaining[zone]-1 < d.MinPerAZ {
+			fmt.Printf(" -> Keeping %s: %s would drop below %d instances\n", *arn, zone, d.MinPerAZ)
+			continue
+		}
+		remaining[zone]--
+		selected = append(selected, arn)
+	}
+
+	zones := make([]string, 0, len(remaining))
+	for zone := range remaining {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	fmt.Println("Instances remaining per availability zone:")
+	for _, zone := range zones {
+		fmt.Printf("\t%s: %d\n", zone, remaining[zone])
+	}
+
+	if len(selected) < drainCount {
+		return nil, fmt.Errorf("can only drain %d of %d container instances while keeping %d instances in every availability zone", len(selected), drainCount, d.MinPerAZ)
+	}
+	return selected, nil
+}
@@ -0,0 +1,110 @@
+package downscaler
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// CandidateInstance describes a container instance that could be drained, for
+// SortFunc to order.
+type CandidateInstance struct {
+	ContainerInstanceArn string
+	EC2InstanceID        string
+	InstanceType         string
+	AvailabilityZone     string
+	LaunchTime           time.Time
+
+	// The number of tasks of any service running on the instance.
+	RunningTasksCount int64
+
+	// The percentage of the instance's registered memory reserved by tasks.
+	MemoryReservedPercent float64
+
+	// The version of the instance's ECS agent, e.g. "1.39.0".
+	AgentVersion string
+
+	// Whether the instance's ECS agent is connected.
+	AgentConnected bool
+
+	// The EC2 instance's tags.
+	Tags map[string]string
+}
+
+// Describes the candidates, by container instance ARN, and remembers the
+// descriptions for the run.
+func (d *DownScaler) describeCandidates(ctx context.Context, arns []*string) (map[string]*CandidateInstance, error) {
+	containerInstances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerEC2Instances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[string]*CandidateInstance, len(containerInstances))
+	for _, ci := range containerInstances {
+		arn := aws.StringValue(ci.ContainerInstanceArn)
+		candidate := &CandidateInstance{
+			ContainerInstanceArn: arn,
+			EC2InstanceID:        aws.StringValue(ci.Ec2InstanceId),
+			RunningTasksCount:    aws.Int64Value(ci.RunningTasksCount),
+			AgentConnected:       aws.BoolValue(ci.AgentConnected),
+			Tags:                 make(map[string]string),
+		}
+		if ci.VersionInfo != nil {
+			candidate.AgentVersion = aws.StringValue(ci.VersionInfo.AgentVersion)
+		}
+		if registered := resourceValue(ci.RegisteredResources, "MEMORY"); registered > 0 {
+			candidate.MemoryReservedPercent = 100 * float64(registered-resourceValue(ci.RemainingResources, "MEMORY")) / float64(registered)
+		}
+		if instance, ok := instances[arn]; ok {
+			candidate.InstanceType = aws.StringValue(instance.InstanceType)
+			candidate.LaunchTime = aws.TimeValue(instance.LaunchTime)
+			if instance.Placement != nil {
+				candidate.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+			}
+			for _, tag := range instance.Tags {
+				candidate.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+		}
+		candidates[arn] = candidate
+		if d.described == nil {
+			d.described = make(map[string]*CandidateInstance)
+		}
+		d.described[arn] = candidate
+	}
+	return candidates, nil
+}
+
+// Orders the candidates with SortFunc, overriding the built-in priorities.
+// Candidates SortFunc considers equal keep their priority order, and those
+// that couldn't be described, so SortFunc can't compare, come last.
+func (d *DownScaler) sortCandidates(ctx context.Context, arns []*string) ([]*string, error) {
+	candidates, err := d.describeCandidates(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*string, 0, len(arns))
+	var undescribed []*string
+	for _, arn := range arns {
+		if _, ok := candidates[*arn]; !ok {
+			undescribed = append(undescribed, arn)
+			continue
+		}
+		sorted = append(sorted, arn)
+		if reason := d.selectionReasons[*arn]; reason != "" {
+			d.selectionReasons[*arn] = reason + "; reordered by SortFunc"
+		} else {
+			d.selectionReasons[*arn] = "SortFunc"
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return d.SortFunc(candidates[*sorted[i]], candidates[*sorted[j]])
+	})
+	return append(sorted, undescribed...), nil
+}
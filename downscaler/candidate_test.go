@@ -0,0 +1,48 @@
+package downscaler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestSortCandidates(t *testing.T) {
+	cluster := newFakeCluster(3)
+	handlers := cluster.handlers()
+	describe := handlers["DescribeContainerInstances"]
+	handlers["DescribeContainerInstances"] = func(input interface{}) (interface{}, error) {
+		out, err := describe(input)
+		if err != nil {
+			return nil, err
+		}
+		// i-01 was deregistered since it was listed.
+		var described []*ecs.ContainerInstance
+		for _, ci := range out.(*ecs.DescribeContainerInstancesOutput).ContainerInstances {
+			if aws.StringValue(ci.Ec2InstanceId) != "i-01" {
+				described = append(described, ci)
+			}
+		}
+		return &ecs.DescribeContainerInstancesOutput{ContainerInstances: described}, nil
+	}
+	config := cluster.config()
+	config.SortFunc = func(a, b *CandidateInstance) bool {
+		return a.EC2InstanceID > b.EC2InstanceID
+	}
+	d, _ := newTestDownScaler(t, config, handlers)
+	d.selectionReasons = map[string]string{fakeContainerArn("i-02"): "cordoned"}
+
+	sorted, err := d.sortCandidates(context.Background(), fakeContainerArns("i-01", "i-02", "i-03"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValueSlice(sorted), aws.StringValueSlice(fakeContainerArns("i-03", "i-02", "i-01")); !sameStrings(got, want) {
+		t.Errorf("sorted %q; want %q", got, want)
+	}
+	for id, want := range map[string]string{"i-02": "cordoned; reordered by SortFunc", "i-03": "SortFunc"} {
+		if got := d.selectionReasons[fakeContainerArn(id)]; got != want {
+			t.Errorf("%s selected for %q; want %q", id, got, want)
+		}
+	}
+}
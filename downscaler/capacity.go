@@ -0,0 +1,139 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// The CPU units and MiB of memory one task needs, and whether it binds static
+// host ports, limiting it to one task per instance.
+type taskRequirements struct {
+	cpu         int64
+	memory      int64
+	staticPorts bool
+}
+
+func (d *DownScaler) taskRequirements(ctx context.Context, taskDefinition string) (taskRequirements, error) {
+	out, err := d.ecs.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: &taskDefinition,
+	})
+	if err != nil {
+		return taskRequirements{}, errors.Wrap(err, "cannot describe task definition")
+	}
+	td := out.TaskDefinition
+
+	var req taskRequirements
+	for _, c := range td.ContainerDefinitions {
+		req.cpu += aws.Int64Value(c.Cpu)
+		if c.Memory != nil {
+			req.memory += aws.Int64Value(c.Memory)
+		} else {
+			req.memory += aws.Int64Value(c.MemoryReservation)
+		}
+		for _, pm := range c.PortMappings {
+			if aws.Int64Value(pm.HostPort) != 0 {
+				req.staticPorts = true
+			}
+		}
+	}
+	// Task level sizes, when given, take precedence over the containers'.
+	if cpu, err := strconv.ParseInt(aws.StringValue(td.Cpu), 10, 64); err == nil {
+		req.cpu = cpu
+	}
+	if memory, err := strconv.ParseInt(aws.StringValue(td.Memory), 10, 64); err == nil {
+		req.memory = memory
+	}
+	return req, nil
+}
+
+func resourceValue(resources []*ecs.Resource, name string) int64 {
+	for _, r := range resources {
+		if aws.StringValue(r.Name) == name {
+			return aws.Int64Value(r.IntegerValue)
+		}
+	}
+	return 0
+}
+
+// Checks that the container instances left after draining have room for
+// DesiredCount tasks of the service. Each surviving instance offers its
+// remaining resources plus whatever the service's own tasks already use on it.
+func (d *DownScaler) checkCapacity(ctx context.Context, service *ecs.Service, draining []*string) error {
+	req, err := d.taskRequirements(ctx, aws.StringValue(service.TaskDefinition))
+	if err != nil {
+		return err
+	}
+
+	allArns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return err
+	}
+	isDraining := make(map[string]bool)
+	for _, arn := range draining {
+		isDraining[*arn] = true
+	}
+	var survivorArns []*string
+	for _, arn := range allArns {
+		if !isDraining[*arn] {
+			survivorArns = append(survivorArns, arn)
+		}
+	}
+	survivors, err := d.describeContainerInstances(ctx, survivorArns)
+	if err != nil {
+		return err
+	}
+
+	tasks, err := d.listServiceTasks(ctx)
+	if err != nil {
+		return err
+	}
+	serviceTasks := make(map[string]int64)
+	for _, task := range tasks {
+		serviceTasks[aws.StringValue(task.ContainerInstanceArn)]++
+	}
+
+	var fits, cpu, memory int64
+	for _, ci := range survivors {
+		own := serviceTasks[aws.StringValue(ci.ContainerInstanceArn)]
+		freeCPU := resourceValue(ci.RemainingResources, "CPU") + own*req.cpu
+		freeMemory := resourceValue(ci.RemainingResources, "MEMORY") + own*req.memory
+		cpu += freeCPU
+		memory += freeMemory
+
+		n := int64(-1)
+		if req.cpu > 0 {
+			n = freeCPU / req.cpu
+		}
+		if req.memory > 0 && (n < 0 || freeMemory/req.memory < n) {
+			n = freeMemory / req.memory
+		}
+		if req.staticPorts && (n < 0 || n > 1) {
+			n = 1
+		}
+		if n < 0 {
+			// The task reserves nothing, so there's nothing to run out of.
+			return nil
+		}
+		fits += n
+	}
+
+	if fits < d.DesiredCount {
+		return fmt.Errorf("the %d remaining container instances can only fit %d of %d tasks (%d CPU units and %d MiB free; each task needs %d CPU units and %d MiB%s); use -skip-capacity-check to scale down anyway",
+			len(survivors), fits, d.DesiredCount, cpu, memory, req.cpu, req.memory, portsNote(req))
+	}
+	log.Printf("Capacity check: the %d remaining container instances fit %d tasks, %d are desired", len(survivors), fits, d.DesiredCount)
+	return nil
+}
+
+func portsNote(req taskRequirements) string {
+	if req.staticPorts {
+		return ", and static host ports allow one task per instance"
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// The SDK we build against predates ECS capacity providers, so
+// DescribeCapacityProviders is called through the ECS client with our own
+// input and output shapes.
+type describeCapacityProvidersInput struct {
+	_ struct{} `type:"structure"`
+
+	CapacityProviders []*string `locationName:"capacityProviders" type:"list"`
+}
+
+type describeCapacityProvidersOutput struct {
+	_ struct{} `type:"structure"`
+
+	CapacityProviders []*capacityProvider `locationName:"capacityProviders" type:"list"`
+	Failures          []*ecs.Failure      `locationName:"failures" type:"list"`
+}
+
+type capacityProvider struct {
+	_ struct{} `type:"structure"`
+
+	Name                     *string                   `locationName:"name" type:"string"`
+	AutoScalingGroupProvider *autoScalingGroupProvider `locationName:"autoScalingGroupProvider" type:"structure"`
+}
+
+type autoScalingGroupProvider struct {
+	_ struct{} `type:"structure"`
+
+	AutoScalingGroupArn *string `locationName:"autoScalingGroupArn" type:"string"`
+}
+
+// Returns the name of the ASG behind the named capacity provider.
+func (d *DownScaler) capacityProviderASG(ctx context.Context, name string) (string, error) {
+	out := &describeCapacityProvidersOutput{}
+	req := d.ecs.NewRequest(&request.Operation{
+		Name:       "DescribeCapacityProviders",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, &describeCapacityProvidersInput{CapacityProviders: []*string{aws.String(name)}}, out)
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		return "", errors.Wrap(err, "cannot describe capacity providers")
+	}
+
+	for _, provider := range out.CapacityProviders {
+		if provider.AutoScalingGroupProvider == nil {
+			return "", fmt.Errorf("capacity provider %s isn't backed by an ASG", name)
+		}
+		arn := aws.StringValue(provider.AutoScalingGroupProvider.AutoScalingGroupArn)
+		i := strings.Index(arn, "autoScalingGroupName/")
+		if i < 0 {
+			return "", fmt.Errorf("cannot find the ASG name in %s", arn)
+		}
+		return arn[i+len("autoScalingGroupName/"):], nil
+	}
+	for _, failure := range out.Failures {
+		return "", fmt.Errorf("cannot find capacity provider %s: %s", name, aws.StringValue(failure.Reason))
+	}
+	return "", fmt.Errorf("cannot find capacity provider %s", name)
+}
+
+// Keeps only the candidates managed by CapacityProviderFilter, i.e. whose EC2
+// instances are in the provider's ASG, which must be the ASG we scale.
+func (d *DownScaler) filterByCapacityProvider(ctx context.Context, arns []*string) ([]*string, error) {
+	asgName, err := d.capacityProviderASG(ctx, d.CapacityProviderFilter)
+	if err != nil {
+		return nil, err
+	}
+	if asgName != d.ASG {
+		return nil, fmt.Errorf("capacity provider %s manages ASG %s, not %s", d.CapacityProviderFilter, asgName, d.ASG)
+	}
+
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inASG := make(map[string]bool)
+	for _, instance := range group.Instances {
+		inASG[aws.StringValue(instance.InstanceId)] = true
+	}
+
+	containerInstances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[string]bool)
+	for _, ci := range containerInstances {
+		if inASG[aws.StringValue(ci.Ec2InstanceId)] {
+			managed[aws.StringValue(ci.ContainerInstanceArn)] = true
+		}
+	}
+
+	var filtered []*string
+	for _, arn := range arns {
+		if managed[*arn] {
+			filtered = append(filtered, arn)
+		}
+	}
+	fmt.Printf(" -> capacityProvider == %s: kept %d of %d candidates\n", d.CapacityProviderFilter, len(filtered), len(arns))
+	return filtered, nil
+}
@@ -0,0 +1,69 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Confirmation is ConfirmInstance's answer for an instance about to be
+// drained.
+type Confirmation int
+
+const (
+	// ConfirmDrain drains the instance.
+	ConfirmDrain Confirmation = iota
+	// ConfirmSkip never drains the instance, draining another one in its
+	// place if there is one.
+	ConfirmSkip
+	// ConfirmAbort stops the run before draining anything more.
+	ConfirmAbort
+)
+
+// ErrConfirmationAborted is returned when ConfirmInstance aborts the run.
+var ErrConfirmationAborted = errors.New("run aborted at an instance's confirmation")
+
+// SkipDeclined is the reason for skipping an instance ConfirmInstance declined.
+const SkipDeclined = "declined at confirmation"
+
+// Asks ConfirmInstance about each selected instance it wasn't asked about yet
+// this run, in order. Returns the ARNs of those it declined.
+func (d *DownScaler) confirmInstances(ctx context.Context, selected []*string) (map[string]bool, error) {
+	var unasked []*string
+	for _, arn := range selected {
+		if _, ok := d.confirmations[*arn]; !ok {
+			unasked = append(unasked, arn)
+		}
+	}
+	declined := make(map[string]bool)
+	if len(unasked) > 0 {
+		candidates, err := d.describeCandidates(ctx, unasked)
+		if err != nil {
+			return nil, err
+		}
+		for _, arn := range unasked {
+			candidate, ok := candidates[*arn]
+			if !ok {
+				return nil, fmt.Errorf("cannot describe %s for confirmation", *arn)
+			}
+			answer, err := d.ConfirmInstance(candidate)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot confirm %s", *arn)
+			}
+			if answer == ConfirmAbort {
+				return nil, ErrConfirmationAborted
+			}
+			d.confirmations[*arn] = answer
+			if answer == ConfirmSkip {
+				d.result.Skipped = append(d.result.Skipped, SkippedInstance{ContainerInstanceArn: *arn, Reason: SkipDeclined})
+			}
+		}
+	}
+	for arn, answer := range d.confirmations {
+		if answer == ConfirmSkip {
+			declined[arn] = true
+		}
+	}
+	return declined, nil
+}
@@ -0,0 +1,83 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// Returns the memberOf expressions of the service's placement constraints and
+// of its task definition's.
+func (d *DownScaler) memberOfExpressions(ctx context.Context, service *ecs.Service) ([]string, error) {
+	var expressions []string
+	for _, constraint := range service.PlacementConstraints {
+		if aws.StringValue(constraint.Type) == ecs.PlacementConstraintTypeMemberOf {
+			expressions = append(expressions, aws.StringValue(constraint.Expression))
+		}
+	}
+	out, err := d.ecs.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: service.TaskDefinition,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot describe task definition")
+	}
+	for _, constraint := range out.TaskDefinition.PlacementConstraints {
+		if aws.StringValue(constraint.Type) == ecs.TaskDefinitionPlacementConstraintTypeMemberOf {
+			expressions = append(expressions, aws.StringValue(constraint.Expression))
+		}
+	}
+	return expressions, nil
+}
+
+// Checks that each memberOf placement constraint of the service still matches
+// an ACTIVE container instance once the given ones are drained, so its tasks
+// can still be placed. ECS evaluates the expressions itself, as filters.
+func (d *DownScaler) checkPlacementConstraints(ctx context.Context, service *ecs.Service, draining []*string) error {
+	expressions, err := d.memberOfExpressions(ctx, service)
+	if err != nil {
+		return err
+	}
+	drained := make(map[string]bool, len(draining))
+	for _, arn := range draining {
+		drained[*arn] = true
+	}
+
+	var unsatisfiable []string
+	for _, expression := range expressions {
+		var matching []*string
+		err := d.ecs.ListContainerInstancesPagesWithContext(ctx, &ecs.ListContainerInstancesInput{
+			Cluster: &d.Cluster,
+			Filter:  aws.String(expression),
+			Status:  aws.String(ecs.ContainerInstanceStatusActive),
+		}, func(page *ecs.ListContainerInstancesOutput, isLastPage bool) bool {
+			matching = append(matching, page.ContainerInstanceArns...)
+			return true
+		})
+		if err != nil {
+			d.warnf("cannot check placement constraint memberOf(%s): %s", expression, err)
+			continue
+		}
+		remaining := 0
+		for _, arn := range matching {
+			if !drained[*arn] {
+				remaining++
+			}
+		}
+		log.Printf("Placement constraint memberOf(%s) matches %d ACTIVE instances, %d of them left after draining", expression, len(matching), remaining)
+		switch {
+		case len(matching) == 0:
+			unsatisfiable = append(unsatisfiable, fmt.Sprintf("memberOf(%s) matches no ACTIVE instances", expression))
+		case remaining == 0:
+			unsatisfiable = append(unsatisfiable, fmt.Sprintf("memberOf(%s) only matches the %d instances to be drained", expression, len(matching)))
+		}
+	}
+	if len(unsatisfiable) > 0 {
+		return fmt.Errorf("service %s couldn't place its tasks once the instances are drained:\n\t%s", d.Service, strings.Join(unsatisfiable, "\n\t"))
+	}
+	return nil
+}
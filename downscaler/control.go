@@ -0,0 +1,70 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How often a paused run checks its control file.
+const controlPollInterval = 10 * time.Second
+
+// ErrAborted is returned when a run is aborted through its control file.
+var ErrAborted = errors.New("run aborted through the control file")
+
+// Reads the command in the control file: "pause", "resume" or "abort". A
+// missing or empty file means "resume".
+func (d *DownScaler) readControlFile() (string, error) {
+	b, err := ioutil.ReadFile(d.ControlFilePath)
+	if os.IsNotExist(err) {
+		return "resume", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "cannot read control file")
+	}
+	command := strings.ToLower(strings.TrimSpace(string(b)))
+	switch command {
+	case "":
+		return "resume", nil
+	case "pause", "resume", "abort":
+		return command, nil
+	}
+	return "", fmt.Errorf("unknown command %q in control file %s; expected pause, resume or abort", command, d.ControlFilePath)
+}
+
+// Waits while the control file says to pause, and fails once it says to abort.
+func (d *DownScaler) checkControlFile(ctx context.Context) error {
+	if d.ControlFilePath == "" {
+		return nil
+	}
+	paused := false
+	for {
+		command, err := d.readControlFile()
+		if err != nil {
+			return err
+		}
+		switch command {
+		case "abort":
+			return ErrAborted
+		case "resume":
+			if paused {
+				log.Println("Resuming")
+			}
+			return nil
+		}
+
+		if !paused {
+			log.Printf("Paused by %s; write resume or abort to it to continue", d.ControlFilePath)
+			paused = true
+		}
+		if err := sleepContext(ctx, controlPollInterval); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package downscaler
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// Reads the EC2 instance IDs and container instance ARNs in the cordon list,
+// one per line. Blank lines and lines starting with # are ignored, and a
+// missing file is an empty list.
+func readCordonList(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read cordon list")
+	}
+	var entries []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// Returns the container instances in the cordon list, in its order.
+func (d *DownScaler) findCordonedContainerInstances(ctx context.Context) ([]*string, error) {
+	entries, err := readCordonList(d.CordonListPath)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	arns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+	byEntry := make(map[string]*string)
+	for _, ci := range instances {
+		byEntry[aws.StringValue(ci.ContainerInstanceArn)] = ci.ContainerInstanceArn
+		byEntry[aws.StringValue(ci.Ec2InstanceId)] = ci.ContainerInstanceArn
+	}
+
+	var cordoned []*string
+	for _, entry := range entries {
+		arn, ok := byEntry[entry]
+		if !ok {
+			log.Printf("Cordoned %s isn't in cluster %s", entry, d.Cluster)
+			continue
+		}
+		log.Printf("Cordoned %s: %s", entry, *arn)
+		cordoned = append(cordoned, arn)
+	}
+	return cordoned, nil
+}
+
+// Removes the instances terminated so far from the cordon list.
+func (d *DownScaler) updateCordonList() error {
+	entries, err := readCordonList(d.CordonListPath)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	terminated := make(map[string]bool)
+	for id, arn := range d.state.Terminated {
+		terminated[id] = true
+		terminated[arn] = true
+	}
+	for _, instance := range d.result.Instances {
+		terminated[instance.EC2InstanceID] = true
+		terminated[instance.ContainerInstanceArn] = true
+	}
+
+	var kept []string
+	for _, entry := range entries {
+		if terminated[entry] {
+			log.Printf("Removing terminated %s from the cordon list", entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if len(kept) == len(entries) {
+		return nil
+	}
+	data := strings.Join(kept, "\n")
+	if data != "" {
+		data += "\n"
+	}
+	return errors.Wrap(writeFileAtomically(d.CordonListPath, []byte(data)), "cannot write cordon list")
+}
@@ -0,0 +1,37 @@
+package downscaler
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// CredentialsExpiredError is returned when an AWS request failed because the
+// run's credentials expired, and refreshing them didn't help, e.g. because
+// they came from an SSO login or an assumed role's session that has ended.
+// The run's cleanup needs credentials too, so the ASG processes and the
+// scalable target it suspended may be left suspended. It keeps the AWS
+// error's code, so it can still be checked as an awserr.Error.
+type CredentialsExpiredError struct {
+	Err awserr.Error
+}
+
+func (e *CredentialsExpiredError) Error() string {
+	return "AWS credentials expired mid-run; re-authenticate, resume any ASG processes and scalable target scaling the run suspended by hand, and re-run: " + e.Err.Error()
+}
+
+func (e *CredentialsExpiredError) Code() string    { return e.Err.Code() }
+func (e *CredentialsExpiredError) Message() string { return e.Err.Message() }
+func (e *CredentialsExpiredError) OrigErr() error  { return e.Err }
+
+// Runs after the SDK decided whether to retry a failed request. The SDK's
+// retryer counts expired credentials errors as retryable, and expires the
+// credentials before the retry so they're refreshed, within the retry limit.
+// One left once it gave up means they can't be refreshed.
+func markExpiredCredentials(r *request.Request) {
+	if r.Error == nil || !r.IsErrorExpired() {
+		return
+	}
+	if aerr, ok := r.Error.(awserr.Error); ok {
+		r.Error = &CredentialsExpiredError{Err: aerr}
+	}
+}
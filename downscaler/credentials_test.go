@@ -0,0 +1,54 @@
+package downscaler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/pkg/errors"
+)
+
+func TestExpiredCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRetries  int
+		expired     int
+		wantExpired bool
+		wantSent    int
+	}{
+		{name: "refreshed and retried", maxRetries: 1, expired: 1, wantSent: 2},
+		{name: "can't be refreshed", maxRetries: 1, expired: 2, wantExpired: true, wantSent: 2},
+		{name: "no retries", maxRetries: 0, expired: 1, wantExpired: true, wantSent: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sent := 0
+			d, _ := newTestDownScaler(t, &Config{ASG: "asg", AWSMaxRetries: aws.Int(test.maxRetries)}, map[string]fakeHandler{
+				"DescribeAutoScalingGroups": func(interface{}) (interface{}, error) {
+					sent++
+					if sent <= test.expired {
+						return nil, awserr.New("ExpiredToken", "The security token included in the request is expired", nil)
+					}
+					return &autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []*autoscaling.Group{{AutoScalingGroupName: aws.String("asg")}},
+					}, nil
+				},
+			})
+
+			_, err := d.describeASG(context.Background())
+			if sent != test.wantSent {
+				t.Errorf("sent %d times; want %d", sent, test.wantSent)
+			}
+			_, expired := errors.Cause(err).(*CredentialsExpiredError)
+			if expired != test.wantExpired {
+				t.Errorf("got error %v; want CredentialsExpiredError %t", err, test.wantExpired)
+			}
+			if !test.wantExpired && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package downscaler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+// Adds the candidates not yet in the drain order to it, in order, for the CSV
+// report, as described while they are still running. Only candidates that
+// weren't described while being selected are described now.
+func (d *DownScaler) recordDrainOrder(ctx context.Context, arns []*string) error {
+	recorded := make(map[string]bool, len(d.drainOrder))
+	for _, c := range d.drainOrder {
+		recorded[c.ContainerInstanceArn] = true
+	}
+	var undescribed []*string
+	for _, arn := range arns {
+		if _, ok := d.described[*arn]; !ok && !recorded[*arn] {
+			undescribed = append(undescribed, arn)
+		}
+	}
+	if len(undescribed) > 0 {
+		if _, err := d.describeCandidates(ctx, undescribed); err != nil {
+			return err
+		}
+	}
+
+	for _, arn := range arns {
+		if candidate, ok := d.described[*arn]; ok && !recorded[*arn] {
+			d.drainOrder = append(d.drainOrder, candidate)
+			recorded[*arn] = true
+		}
+	}
+	return nil
+}
+
+// Writes the candidates in drain order to CSVReportPath, a row each, with
+// whether the run terminated them, and whether it did so with tasks still
+// running.
+func (d *DownScaler) writeCSVReport() error {
+	terminated := make(map[string]bool)
+	for _, id := range d.result.Terminated {
+		terminated[id] = true
+	}
+	forced := make(map[string]bool)
+	for _, id := range d.result.ForceTerminated {
+		forced[id] = true
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		"container_instance_arn", "ec2_instance_id", "instance_type", "availability_zone", "launch_time",
+		"running_tasks_count", "agent_version", "selection_reason", "terminated", "force_terminated",
+	})
+	for _, c := range d.drainOrder {
+		launchTime := ""
+		if !c.LaunchTime.IsZero() {
+			launchTime = c.LaunchTime.UTC().Format(time.RFC3339)
+		}
+		w.Write([]string{
+			c.ContainerInstanceArn, c.EC2InstanceID, c.InstanceType, c.AvailabilityZone, launchTime,
+			strconv.FormatInt(c.RunningTasksCount, 10), c.AgentVersion, d.selectionReasons[c.ContainerInstanceArn],
+			strconv.FormatBool(terminated[c.EC2InstanceID]), strconv.FormatBool(forced[c.EC2InstanceID] && terminated[c.EC2InstanceID]),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.CSVReportPath, buf.Bytes(), 0644)
+}
@@ -0,0 +1,63 @@
+package downscaler
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Instances picked when re-evaluating later batches are added to the report
+// after those planned first.
+func TestCSVReportReevaluated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cluster := newFakeCluster(4)
+	config := cluster.config()
+	config.DesiredCount = 2
+	config.ReevaluateEachBatch = true
+	config.CSVReportPath = filepath.Join(dir, "report.csv")
+	handlers := cluster.handlers()
+	drain := handlers["UpdateContainerInstancesState"]
+	handlers["UpdateContainerInstancesState"] = func(input interface{}) (interface{}, error) {
+		out, err := drain(input)
+		// The second planned instance is replaced before its batch, so
+		// re-evaluating picks another.
+		for i, id := range cluster.instances {
+			if id == "i-02" {
+				cluster.instances = append(append(cluster.instances[:i], cluster.instances[i+1:]...), "i-05")
+				break
+			}
+		}
+		return out, err
+	}
+	d, _ := newTestDownScaler(t, config, handlers)
+
+	if _, err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(config.CSVReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][]string
+	for _, row := range rows[1:] {
+		got = append(got, []string{row[1], row[8]})
+	}
+	want := [][]string{{"i-01", "true"}, {"i-02", "false"}, {"i-03", "true"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report rows (ec2_instance_id, terminated) %q; want %q", got, want)
+	}
+}
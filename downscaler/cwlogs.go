@@ -0,0 +1,186 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/pkg/errors"
+)
+
+// PutLogEvents limits: events per call, and bytes per call, counting 26
+// bytes of overhead per event.
+const (
+	maxLogEventsPerPut = 10000
+	maxLogBytesPerPut  = 1048576
+	logEventOverhead   = 26
+)
+
+// Buffers the run's log lines and sends them to a CloudWatch Logs stream
+// when flushed. Writes never block on CloudWatch.
+type cloudWatchWriter struct {
+	client *cloudwatchlogs.CloudWatchLogs
+	group  string
+	stream string
+
+	mu     sync.Mutex
+	events []*cloudwatchlogs.InputLogEvent
+
+	// Held while flushing, which owns the sequence token.
+	flushMu       sync.Mutex
+	sequenceToken *string
+}
+
+// Creates the log stream, unless it already exists, and returns a writer to it.
+func newCloudWatchWriter(ctx context.Context, client *cloudwatchlogs.CloudWatchLogs, group, stream string) (*cloudWatchWriter, error) {
+	w := &cloudWatchWriter{client: client, group: group, stream: stream}
+	_, err := client.CreateLogStreamWithContext(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  &group,
+		LogStreamName: &stream,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		// Appending to an existing stream needs its next sequence token.
+		err = w.refreshSequenceToken(ctx)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create log stream %s in log group %s", stream, group)
+	}
+	return w, nil
+}
+
+func (w *cloudWatchWriter) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	if message == "" {
+		return len(p), nil
+	}
+	w.mu.Lock()
+	w.events = append(w.events, &cloudwatchlogs.InputLogEvent{
+		Message:   &message,
+		Timestamp: aws.Int64(time.Now().UnixNano() / int64(time.Millisecond)),
+	})
+	w.mu.Unlock()
+	return len(p), nil
+}
+
+func (w *cloudWatchWriter) refreshSequenceToken(ctx context.Context) error {
+	out, err := w.client.DescribeLogStreamsWithContext(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        &w.group,
+		LogStreamNamePrefix: &w.stream,
+	})
+	if err != nil {
+		return err
+	}
+	for _, stream := range out.LogStreams {
+		if aws.StringValue(stream.LogStreamName) == w.stream {
+			w.sequenceToken = stream.UploadSequenceToken
+			return nil
+		}
+	}
+	return fmt.Errorf("log stream %s not found in log group %s", w.stream, w.group)
+}
+
+// Sends the buffered events, in as few calls as the limits allow. Events
+// that can't be sent are dropped, so a failing CloudWatch doesn't hold on
+// to the run's whole log.
+func (w *cloudWatchWriter) flush(ctx context.Context) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	events := w.events
+	w.events = nil
+	w.mu.Unlock()
+
+	for len(events) > 0 {
+		n, size := 0, 0
+		for n < len(events) && n < maxLogEventsPerPut {
+			eventSize := len(aws.StringValue(events[n].Message)) + logEventOverhead
+			if n > 0 && size+eventSize > maxLogBytesPerPut {
+				break
+			}
+			size += eventSize
+			n++
+		}
+		if err := w.put(ctx, events[:n]); err != nil {
+			return errors.Wrapf(err, "cannot put %d events to log stream %s", n, w.stream)
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// Puts the events with the stream's sequence token, fetching the token again
+// and retrying once if another writer moved it on.
+func (w *cloudWatchWriter) put(ctx context.Context, events []*cloudwatchlogs.InputLogEvent) error {
+	for attempt := 0; ; attempt++ {
+		out, err := w.client.PutLogEventsWithContext(ctx, &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  &w.group,
+			LogStreamName: &w.stream,
+			LogEvents:     events,
+			SequenceToken: w.sequenceToken,
+		})
+		if err == nil {
+			w.sequenceToken = out.NextSequenceToken
+			return nil
+		}
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return err
+		}
+		switch {
+		case aerr.Code() == cloudwatchlogs.ErrCodeDataAlreadyAcceptedException:
+			// A retried request already got through.
+			return w.refreshSequenceToken(ctx)
+		case aerr.Code() == cloudwatchlogs.ErrCodeInvalidSequenceTokenException && attempt == 0:
+			if err := w.refreshSequenceToken(ctx); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// Copies everything logged from now on to CloudWatchLogGroup, and returns a
+// func that flushes what's left and stops copying.
+func (d *DownScaler) startCloudWatchLogs(ctx context.Context) (func(), error) {
+	stream := d.CloudWatchLogStream
+	if stream == "" {
+		stream = "ecs-down-" + d.startedAt.UTC().Format("20060102T150405Z")
+	}
+	w, err := newCloudWatchWriter(ctx, d.logs, d.CloudWatchLogGroup, stream)
+	if err != nil {
+		return nil, err
+	}
+	local := log.Writer()
+	log.SetOutput(io.MultiWriter(local, w))
+	d.logWriter = w
+	log.Printf("Sending logs to CloudWatch Logs stream %s in log group %s", stream, d.CloudWatchLogGroup)
+	return func() {
+		log.SetOutput(local)
+		d.logWriter = nil
+		// The run's context may be cancelled by now.
+		if err := w.flush(context.Background()); err != nil {
+			log.Printf("Cannot send logs to CloudWatch Logs: %s", err)
+		}
+	}, nil
+}
+
+// Flushes the run's logs to CloudWatch Logs, if they're sent there, saying
+// so on the local log if it can't.
+func (d *DownScaler) flushLogs(ctx context.Context) {
+	if d.logWriter == nil {
+		return
+	}
+	if err := d.logWriter.flush(ctx); err != nil {
+		// Logged after the flush, so it's sent with the next one.
+		log.Printf("Cannot send logs to CloudWatch Logs: %s", err)
+	}
+}
@@ -5,26 +5,201 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
 )
 
 type DownScaler struct {
 	*Config
-	asg *autoscaling.AutoScaling
-	ec2 *ec2.EC2
-	ecs *ecs.ECS
+	aas   *applicationautoscaling.ApplicationAutoScaling
+	asg   *autoscaling.AutoScaling
+	ec2   *ec2.EC2
+	ecs   *ecs.ECS
+	elbv2 *elbv2.ELBV2
+
+	events   *eventbridge.EventBridge
+	sqs      *sqs.SQS
+	dynamodb *dynamodb.DynamoDB
+	sts      *sts.STS
+	iam      *iam.IAM
+	logs     *cloudwatchlogs.CloudWatchLogs
+	s3       *s3.S3
+
+	// Where the run's logs are copied for CloudWatchLogGroup, while it runs.
+	logWriter *cloudWatchWriter
+
+	state    *runState
+	result   *Result
+	progress Progress
+
+	// Guards the result's warnings, which parallel discovery passes add to.
+	// A pointer, so the copies multi-cluster and ramp runs make share it.
+	warningsMu *sync.Mutex
+
+	// Container instance ARN -> why it was selected for draining.
+	selectionReasons map[string]string
+
+	// Container instance ARN -> ConfirmInstance's answer for it this run.
+	confirmations map[string]Confirmation
+
+	// The candidates in the order first planned for draining, for the CSV
+	// report.
+	drainOrder []*CandidateInstance
+
+	// Container instance ARN -> its latest description as a candidate.
+	described map[string]*CandidateInstance
+
+	// When the run started, and the sizes it started from, for HistoryTable.
+	startedAt   time.Time
+	sizesBefore runSizes
+
+	// Set when the service's desired count isn't ours to update: it's a
+	// DAEMON service, or its tasks are managed by an EXTERNAL deployment
+	// controller.
+	keepTaskCount bool
+}
+
+// Result summarizes what a run did. See JSONSchema for its JSON encoding.
+type Result struct {
+	// EC2 instance IDs that were terminated.
+	Terminated []string `json:"terminated"`
+
+	// EC2 instance IDs that were still running tasks when DrainGracePeriod
+	// expired and were terminated anyway because of ForceAfterGrace.
+	ForceTerminated []string `json:"force_terminated"`
+
+	// Container instances that couldn't be set to DRAINING.
+	DrainFailures []DrainFailure `json:"drain_failures"`
+
+	// NoChange is set when the cluster was already at the desired size.
+	NoChange bool `json:"no_change"`
+
+	// The run's Reason, if given.
+	Reason string `json:"reason,omitempty"`
+
+	// Details of each terminated instance, including why it was selected.
+	Instances []TerminatedInstance `json:"instances"`
+
+	// Container instances that were set to DRAINING. Those not in Terminated
+	// were kept in WarmPool, or left draining because the run failed before
+	// terminating them.
+	Drained []string `json:"drained"`
+
+	// EC2 instance IDs of drained instances kept running, still DRAINING,
+	// as a warm pool instead of being terminated. Only set with WarmPoolSize.
+	WarmPool []string `json:"warm_pool,omitempty"`
+
+	// Container instances selected for draining that were passed over.
+	Skipped []SkippedInstance `json:"skipped"`
+
+	// Warnings logged during the run. Runs fail once done if there are any
+	// and FailOnWarning is set.
+	Warnings []string `json:"warnings"`
+
+	// The number of ACTIVE, connected container instances once the run was
+	// done. Only set when MinHealthyAfter is.
+	HealthyInstances int64 `json:"healthy_instances,omitempty"`
+
+	// How long each batch took, in order. Encoded to JSON in seconds.
+	BatchDurations []time.Duration `json:"-"`
+
+	// The batch, counting from 1, that failed the run, if one did.
+	FailedBatch int `json:"failed_batch,omitempty"`
+
+	// The sizes the ASG was finally set to, and those it was found with
+	// afterwards. Only set with VerifyASGSettle.
+	ExpectedASGSizes *ASGSizes `json:"expected_asg_sizes,omitempty"`
+	ObservedASGSizes *ASGSizes `json:"observed_asg_sizes,omitempty"`
+
+	// The batches a dry run would drain. Only set by dry runs.
+	Plan *Plan `json:"plan,omitempty"`
+
+	// The requests a Record run would have made, in order.
+	Recorded []RecordedCall `json:"recorded,omitempty"`
+
+	// Results of each step of a RampSchedule, in order. Terminated and
+	// ForceTerminated aggregate them across all steps.
+	RampSteps []*Result `json:"ramp_steps,omitempty"`
+
+	// Results of each cluster in a multi-cluster run, keyed by cluster name.
+	// Terminated and ForceTerminated aggregate them across all clusters.
+	Clusters map[string]*Result `json:"clusters,omitempty"`
+}
+
+// ASGSizes are an ASG's desired capacity, minimum and maximum size.
+type ASGSizes struct {
+	DesiredCapacity int64 `json:"desired_capacity"`
+	MinSize         int64 `json:"min_size"`
+	MaxSize         int64 `json:"max_size"`
+}
+
+// DrainFailure describes a container instance ECS failed to set to DRAINING.
+type DrainFailure struct {
+	ContainerInstanceArn string `json:"container_instance_arn"`
+	Reason               string `json:"reason"`
+}
+
+// Reasons for skipping an instance.
+const (
+	SkipAlreadyTerminated = "already terminated by a previous run"
+)
+
+// SkippedInstance describes an instance selected for draining that was passed
+// over.
+type SkippedInstance struct {
+	ContainerInstanceArn string `json:"container_instance_arn"`
+	Reason               string `json:"reason"`
+}
+
+// TerminatedInstance describes a terminated instance and why it was selected
+// for termination.
+type TerminatedInstance struct {
+	ContainerInstanceArn string    `json:"container_instance_arn"`
+	EC2InstanceID        string    `json:"ec2_instance_id"`
+	InstanceType         string    `json:"instance_type"`
+	AvailabilityZone     string    `json:"availability_zone"`
+	LaunchTime           time.Time `json:"launch_time"`
+
+	// The discovery pass that selected the instance, e.g.
+	// "agentVersion < 1.39.0", or "leftover" if no preference matched it.
+	Reason string `json:"reason"`
+}
+
+// ClusterSpec identifies a cluster, its service and its ASG for a
+// multi-cluster run.
+type ClusterSpec struct {
+	Cluster string
+	Service string
+	ASG     string
 }
 
 type Config struct {
-	Service          string
-	Cluster          string
-	ASG              string
-	DesiredCount     int64
+	Service      string
+	Cluster      string
+	ASG          string
+	DesiredCount int64
+	// ASGDesiredCount is the number of instances the ASG should end up with,
+	// when it differs from the service's DesiredCount task count because
+	// instances run more than one task each. Zero uses DesiredCount.
+	ASGDesiredCount  int64
 	BatchSize        int
 	InstanceType     string
 	Region           string
@@ -33,6 +208,421 @@ type Config struct {
 	TaskCountDetect  bool
 	AllowASGMismatch bool
 
+	// InstanceTypes are preferred for draining after InstanceType, in order,
+	// each type as a priority group of its own.
+	InstanceTypes []string
+
+	// PreferDisconnectedAgents drains container instances whose ECS agent is
+	// disconnected before any other instances.
+	PreferDisconnectedAgents bool
+
+	// PreferUnhealthy drains container instances hosting the service's load
+	// balancer targets that fail health checks before any other instances.
+	PreferUnhealthy bool
+
+	// PreferRebalanceRecommended prefers draining container instances on
+	// spot instances EC2 has marked for interruption, which are going away
+	// anyway. EC2's API only reports the spot request's status, not
+	// rebalance recommendations themselves.
+	PreferRebalanceRecommended bool
+
+	// PreferFailedTaskHosts prefers draining container instances where tasks
+	// of any service failed within FailedTaskLookback, zero meaning an hour,
+	// those with the most failures first.
+	PreferFailedTaskHosts bool
+	FailedTaskLookback    time.Duration
+
+	// LaunchedBefore prefers draining instances launched before this time,
+	// e.g. the last AMI update. The zero time disables it.
+	LaunchedBefore time.Time
+
+	// TaskDefinitionFilter prefers draining container instances running tasks
+	// of this task definition: a family, a family:revision or a full ARN.
+	TaskDefinitionFilter string
+
+	// SuspendASGProcesses lists ASG scaling processes (e.g. "Launch",
+	// "AZRebalance") to suspend for the duration of the run. They are resumed
+	// when Run returns, whether or not it succeeded.
+	SuspendASGProcesses []string
+
+	// ForceNewDeployment forces a new deployment of the service when
+	// InstanceFlip restores the original task count, so tasks are replaced
+	// onto fresh instances rather than only having their count bounced.
+	ForceNewDeployment bool
+
+	// StatePath is a file recording the instances terminated so far. When a
+	// run is retried with the same StatePath, instances it already terminated
+	// are not drained or terminated again. Without it, this is only tracked
+	// in memory across calls to Run on the same DownScaler.
+	StatePath string
+
+	// DrainGracePeriod is how long to wait for drained container instances
+	// to stop their tasks before terminating them. Zero doesn't wait.
+	DrainGracePeriod time.Duration
+
+	// TerminateTimeout is how long to wait for terminated instances to shut
+	// down before failing the run. Zero waits as long as the SDK's waiter.
+	TerminateTimeout time.Duration
+
+	// ForceAfterGrace terminates instances still running tasks once
+	// DrainGracePeriod expires. Otherwise the run fails instead.
+	ForceAfterGrace bool
+
+	// ParallelDiscovery runs the candidate discovery passes concurrently.
+	// Candidates are still ordered by pass priority.
+	ParallelDiscovery bool
+
+	// AllowedWindows restricts runs to these daily windows, evaluated in
+	// WindowTimezone, which is required when any windows are set.
+	// ForceOutsideWindow runs anyway, with a warning.
+	AllowedWindows     []TimeWindow
+	WindowTimezone     string
+	ForceOutsideWindow bool
+
+	// DeregisterContainerInstances deregisters terminated container instances
+	// from the cluster straight away rather than leaving ECS to clean them up.
+	DeregisterContainerInstances bool
+
+	// ProgressFunc, if set, is called every time the run changes phase.
+	ProgressFunc func(Progress)
+
+	// DryRun finds the instances to drain and prints the batches a run would
+	// make, with an estimate of its duration, without changing anything.
+	DryRun bool
+
+	// RespectSpread aborts before draining a batch that would remove all of
+	// the service's tasks from a value of a dimension its spread placement
+	// strategy spreads over, such as an availability zone.
+	RespectSpread bool
+
+	// HealthCheckURL is checked with a GET between batches. The run aborts
+	// once it fails, by erroring, returning a non-2xx status or taking longer
+	// than HealthCheckMaxLatency, HealthCheckFailures times in a row.
+	HealthCheckURL        string
+	HealthCheckFailures   int
+	HealthCheckMaxLatency time.Duration
+
+	// Clusters runs the whole scale-down once per cluster, in order, in place
+	// of Cluster, Service and ASG. Every other setting, including
+	// DesiredCount, applies to each cluster.
+	Clusters []ClusterSpec
+
+	// MinPerAZ is the number of instances every availability zone must keep.
+	// Candidates that would take a zone below it are skipped in favour of
+	// others, and the run fails if not enough instances can be drained.
+	MinPerAZ int
+
+	// AbsoluteMinInstances is a floor for the cluster's instance count: a
+	// run that would leave fewer instances is refused before it starts,
+	// whatever the desired count.
+	AbsoluteMinInstances int64
+
+	// WarmPoolSize keeps up to this many of the drained instances running,
+	// still DRAINING and in the ASG, for a quick scale-up later, and
+	// terminates only the rest. The ASG's capacity includes them.
+	WarmPoolSize int
+
+	// DrainToTerminateDelay is a fixed pause between draining a batch and
+	// terminating it, for apps with a predictable shutdown time. Zero pauses
+	// for the longest stopTimeout in the service's task definition, if any.
+	DrainToTerminateDelay time.Duration
+
+	// InstanceWeights maps instance types to the capacity units they count
+	// for in an ASG with a weighted mixed instances policy. The SDK this tool
+	// is built with can't read the policy's weights, so they must be given
+	// here. When set, the ASG's desired capacity is managed in weighted
+	// units, while DesiredCount and ASGDesiredCount remain instance counts.
+	// Instance types missing from the map count as 1 unit.
+	InstanceWeights map[string]int64
+
+	// FailIfNoChange makes Run return ErrNoCandidates when the cluster is
+	// already at the desired size. Otherwise that's logged and Run succeeds.
+	FailIfNoChange bool
+
+	// Reason is a human-supplied justification for the run, e.g. "cost
+	// savings Q3". It's logged at the start and added to the Result, the
+	// EventBridge events and the HistoryTable item, so every record of the
+	// run says why it happened. It's also appended to StopReason and added
+	// to the TagBeforeTerminate tags (as ReasonTag), but only when those are
+	// set: a Reason alone neither stops tasks nor tags instances.
+	Reason string
+
+	// RequireReason refuses to run without a Reason.
+	RequireReason bool
+
+	// StopReason, if set, is the reason given when stopping any tasks still
+	// running on drained instances just before they are terminated, e.g.
+	// "ecs-down scale-down by alice". It shows up in the console and
+	// CloudTrail. ECS doesn't allow a reason for the tasks draining stops.
+	StopReason string
+
+	// SuspendScalableTarget suspends the scaling activities of the service's
+	// Application Auto Scaling target for the duration of the run, and
+	// restores them afterwards. Without it, a service with a scalable target
+	// is refused, since the autoscaler would undo the scale-down.
+	SuspendScalableTarget bool
+
+	// DeregisterScalableTarget is an alias for SuspendScalableTarget. The
+	// target is suspended rather than deregistered, which would delete its
+	// scaling policies and scheduled actions.
+	DeregisterScalableTarget bool
+
+	// SkipCapacityCheck skips checking, before draining anything, that the
+	// remaining instances have the CPU, memory and ports for DesiredCount
+	// tasks of the service's task definition.
+	SkipCapacityCheck bool
+
+	// SkipConstraintCheck only warns, instead of failing the run, when the
+	// instances left after draining no longer match one of the memberOf
+	// placement constraints of the service or its task definition.
+	SkipConstraintCheck bool
+
+	// ContinueOnError carries on with the rest of a batch when some of its
+	// container instances fail to drain, rather than aborting the run.
+	ContinueOnError bool
+
+	// MaxCurrentTasks refuses to scale down, with ErrLoadTooHigh, while the
+	// service is running more tasks than this, e.g. during a traffic spike.
+	// Zero disables the check.
+	MaxCurrentTasks int64
+
+	// ReevaluateEachBatch finds the best instances to drain again before
+	// every batch, rather than planning all batches up front, so long runs
+	// follow changes in the cluster. It never drains more instances than
+	// were first planned.
+	ReevaluateEachBatch bool
+
+	// StepByTaskDensity lowers the service's task count, with each batch, by
+	// the number of its tasks actually running on the batch's instances,
+	// rather than spreading the task goal over the batches by their size.
+	// The count never goes below DesiredCount, and the last batch always
+	// brings it there.
+	StepByTaskDensity bool
+
+	// UseFIPSEndpoints sends every AWS request to FIPS endpoints. Runs fail
+	// if a service they need has no FIPS endpoint in the region.
+	UseFIPSEndpoints bool
+
+	// FailOnWarning fails runs that log any warnings, once they're done.
+	FailOnWarning bool
+
+	// AWSMaxRetries is how many times the SDK retries throttled or failed AWS
+	// requests. Nil keeps the SDK's default.
+	AWSMaxRetries *int
+
+	// Retryer replaces the SDK's retry strategy for all AWS requests. It takes
+	// precedence over AWSMaxRetries.
+	Retryer request.Retryer
+
+	// DecrementOnTerminate has each termination decrement the ASG's desired
+	// capacity, instead of lowering it before terminating, for groups that
+	// launch replacements otherwise. It has no effect with InstanceFlip.
+	DecrementOnTerminate bool
+
+	// CapacityProviderFilter only drains container instances managed by the
+	// named capacity provider. Its ASG must be ASG.
+	CapacityProviderFilter string
+
+	// Record makes a full run, but prints the requests that would change
+	// anything instead of sending them, and records them on the result.
+	// Waits for those changes to take effect are skipped.
+	Record bool
+
+	// ControlFilePath is a file checked between batches for a command:
+	// "pause" waits until it changes, "abort" stops the run and "resume", or
+	// no file, carries on.
+	ControlFilePath string
+
+	// WaitForReschedule waits after each update of the service until it runs
+	// all its desired tasks and all its load balancer targets are healthy,
+	// which the stable waiter doesn't guarantee.
+	WaitForReschedule bool
+
+	// WaitForRescheduleTimeout is how long WaitForReschedule waits before
+	// failing the run. Zero waits 15 minutes.
+	WaitForRescheduleTimeout time.Duration
+
+	// PlanFormat is how dry runs print their plan: "text", the default, or
+	// "tfplan", in the style of a Terraform plan.
+	PlanFormat string
+
+	// ProtectNewest never drains the given number of most recently launched
+	// instances.
+	ProtectNewest int
+
+	// FinalShrinkDelay waits before the final ASG update, which sets its
+	// maximum size, giving a last chance to abort the run.
+	FinalShrinkDelay time.Duration
+
+	// MinServiceReplicas limits each batch to the container instances that can
+	// drain at once while the service keeps at least this many tasks, going
+	// by where its tasks run.
+	MinServiceReplicas int64
+
+	// SortFunc, if set, orders the candidates for draining instead of the
+	// built-in priorities, draining a before b if it returns true. Library
+	// only.
+	SortFunc func(a, b *CandidateInstance) bool
+
+	// ScoreWeights, if set, orders the candidates for draining by a weighted
+	// score instead of the built-in priorities. SortFunc takes precedence.
+	ScoreWeights *ScoreWeights
+
+	// ConfirmInstance, if set, is asked about each instance before it is
+	// drained, and can drain it, skip it for another or abort the run. It
+	// isn't asked in dry runs or Record runs.
+	ConfirmInstance func(*CandidateInstance) (Confirmation, error)
+
+	// TerminateOrphansWithEC2 terminates drained instances that aren't in the
+	// ASG, e.g. launched by hand or detached, through EC2. Otherwise they
+	// fail the run.
+	TerminateOrphansWithEC2 bool
+
+	// WaitBeforeASGShrink waits for drained container instances to stop all
+	// their tasks before lowering the ASG's capacity.
+	WaitBeforeASGShrink bool
+
+	// WaitBeforeASGShrinkTimeout is how long WaitBeforeASGShrink waits, since
+	// tasks of other services, e.g. daemons, may never stop. Then the run
+	// fails, or shrinks the ASG anyway with ForceAfterGrace. Zero waits 15
+	// minutes.
+	WaitBeforeASGShrinkTimeout time.Duration
+
+	// EventBridgeBus, if set, is the EventBridge event bus to put an event on
+	// every time the run changes phase. Failures to put events are only logged.
+	EventBridgeBus string
+
+	// StableWaitRetries is how many more times to wait for the service to
+	// become stable after an update when waiting times out. Failed
+	// deployments are never waited for again.
+	StableWaitRetries int
+
+	// LIFO sorts each priority group newest instance first, the opposite of
+	// SortByAge, which it can't be combined with.
+	LIFO bool
+
+	// MinHealthyAfter waits once the run is done until at least this many
+	// container instances are ACTIVE with their agent connected, failing the
+	// run if they aren't within MinHealthyAfterTimeout.
+	MinHealthyAfter        int64
+	MinHealthyAfterTimeout time.Duration
+
+	// MaxConcurrentDraining caps how many of the cluster's container
+	// instances may be DRAINING at once, whoever drained them, apart from
+	// the run's warm pool. Batches are shrunk, and wait if need be, to stay
+	// under it.
+	MaxConcurrentDraining int
+
+	// Explain logs each step of selecting instances to drain: the filters
+	// run and what they matched, the duplicates dropped and the final order
+	// with each instance's reason.
+	Explain bool
+
+	// TargetHealthyRatio, if set, replaces DesiredCount with the service's
+	// running task count times this ratio, rounded up, when the run starts.
+	// It must be in (0, 1].
+	TargetHealthyRatio float64
+
+	// ScheduleS3URI, if set, replaces DesiredCount with the count a JSON
+	// schedule in S3 gives for the current time, e.g.
+	// "s3://capacity/visage-prod.json". DesiredCount is kept, with a warning,
+	// if the object is missing, can't be parsed or has no entry for now.
+	ScheduleS3URI string
+
+	// TagBeforeTerminate tags each instance with these tags just before
+	// terminating it, for auditing. "{now}" in a value is replaced with the
+	// time, in RFC 3339. Tagging is best effort and never stops termination.
+	TagBeforeTerminate map[string]string
+
+	// AttributeExpiryKey is a container instance attribute holding an RFC
+	// 3339 time after which the instance should be retired, e.g.
+	// "ecs.drain-after". Instances past their time are prioritized.
+	AttributeExpiryKey string
+
+	// MaxBatchDuration fails the run if a batch takes longer than this to
+	// drain, scale and terminate, rather than letting it hang.
+	MaxBatchDuration time.Duration
+
+	// RampSchedule runs the whole scale-down once per step, in order, in
+	// place of DesiredCount, holding for each step's Hold in between. The
+	// ramp stops if a step fails or the service turns unhealthy while held.
+	RampSchedule []RampStep
+
+	// RequireTagAbsent never drains instances with an EC2 tag of any of
+	// these keys, whatever its value, e.g. a tenant lock.
+	RequireTagAbsent []string
+
+	// JUnitReportPath writes a JUnit XML report of the run there once it's
+	// done, with a test case per batch, for CI dashboards. Multi-cluster and
+	// ramp runs write a report per cluster or step, named after it.
+	JUnitReportPath string
+
+	// CSVReportPath writes the candidates there once the run is done, dry
+	// runs included, a row each in drain order with their details, why they
+	// were selected and whether they were terminated. Multi-cluster and ramp
+	// runs write a report per cluster or step, named after it.
+	CSVReportPath string
+
+	// VerifyASGSettle checks, once the run is done, that the ASG's desired
+	// capacity, minimum and maximum size are still those it was set to,
+	// allowing this long for them to settle, and fails the run if not.
+	VerifyASGSettle time.Duration
+
+	// WaitForASGSettle is how long to wait, before changing anything, for
+	// scaling activities the ASG already has in progress to finish. Zero
+	// fails the run straight away if there are any.
+	WaitForASGSettle time.Duration
+
+	// SQSQueueURL, if set, is a queue the service's tasks work from. Before
+	// terminating drained instances, the run waits until the queue has at
+	// most SQSMaxInFlight messages in flight, failing after SQSSettleTimeout
+	// (zero waits forever), so workers aren't terminated mid-message.
+	SQSQueueURL      string
+	SQSMaxInFlight   int64
+	SQSSettleTimeout time.Duration
+
+	// CordonListPath is a file of EC2 instance IDs or container instance
+	// ARNs, one per line, to retire over however many runs it takes. They're
+	// drained before any others, and removed from the file once terminated.
+	CordonListPath string
+
+	// SkipStableWait makes each change and moves straight on, without
+	// waiting for the service to be stable, the ASG's instances to be in
+	// service or terminated instances to shut down. A run can then return
+	// before its changes have taken effect, and fail to notice they didn't.
+	SkipStableWait bool
+
+	// MaxReservationPercent, if set, prefers draining container instances
+	// with less than this percentage of their memory reserved by tasks.
+	MaxReservationPercent float64
+
+	// DelayStart, if set, waits until this time before starting the run,
+	// e.g. to run in a maintenance window later in the day.
+	DelayStart time.Time
+
+	// WarnOnInstanceStore warns before terminating instances that have
+	// instance store volumes, whose data is lost with them.
+	WarnOnInstanceStore bool
+
+	// HistoryTable, if set, is a DynamoDB table with a "runId" string hash
+	// key to record every run in: who ran it, when, against what, what it
+	// terminated and how it ended. Failing to is only warned about.
+	HistoryTable string
+
+	// PreflightIAMCheck simulates the caller's IAM policies for every action
+	// the run needs before starting, failing with those that are missing.
+	PreflightIAMCheck bool
+
+	// OverrideKillSwitch runs even if the cluster carries the KillSwitchTag
+	// tag, which otherwise refuses the run.
+	OverrideKillSwitch bool
+
+	// CloudWatchLogGroup, if set, is a log group the run's log is copied to,
+	// in CloudWatchLogStream, or else a stream named after the run's start
+	// time. Lines are sent at each phase of a batch and once the run is done.
+	CloudWatchLogGroup  string
+	CloudWatchLogStream string
+
 	AgentVersionThreshold string
 }
 
@@ -40,106 +630,770 @@ func New(config *Config) *DownScaler {
 	awsConfig := &aws.Config{
 		Region: &config.Region,
 	}
+	if config.UseFIPSEndpoints {
+		awsConfig.EndpointResolver = endpoints.ResolverFunc(fipsResolver)
+	}
+	if config.AWSMaxRetries != nil {
+		awsConfig.MaxRetries = config.AWSMaxRetries
+	}
+	if config.Retryer != nil {
+		awsConfig = request.WithRetryer(awsConfig, config.Retryer)
+	}
 	awsSession := session.Must(session.NewSession(awsConfig))
 
-	return &DownScaler{
-		Config: config,
-		asg:    autoscaling.New(awsSession),
-		ec2:    ec2.New(awsSession),
-		ecs:    ecs.New(awsSession),
+	d := &DownScaler{
+		Config:     config,
+		state:      &runState{Terminated: make(map[string]string)},
+		result:     &Result{},
+		warningsMu: &sync.Mutex{},
 	}
+	awsSession.Handlers.Build.PushBack(d.recordRequest)
+	awsSession.Handlers.AfterRetry.PushBack(markExpiredCredentials)
+	d.aas = applicationautoscaling.New(awsSession)
+	d.asg = autoscaling.New(awsSession)
+	d.ec2 = ec2.New(awsSession)
+	d.ecs = ecs.New(awsSession)
+	d.elbv2 = elbv2.New(awsSession)
+	d.events = eventbridge.New(awsSession)
+	d.sqs = sqs.New(awsSession)
+	d.dynamodb = dynamodb.New(awsSession)
+	d.sts = sts.New(awsSession)
+	d.iam = iam.New(awsSession)
+	d.logs = cloudwatchlogs.New(awsSession)
+	d.s3 = s3.New(awsSession)
+	return d
 }
 
-func (d *DownScaler) Run() error {
-	ctx := context.Background()
+func (d *DownScaler) Run() (*Result, error) {
+	return d.RunWithContext(context.Background())
+}
+
+// RunWithContext is Run, stopping early once ctx is done, e.g. on Ctrl-C.
+// Suspended ASG processes and scaling policies are resumed either way.
+func (d *DownScaler) RunWithContext(ctx context.Context) (result *Result, err error) {
+	d.result = &Result{Reason: d.Reason}
+	d.progress = Progress{}
+	d.startedAt = time.Now()
+	d.sizesBefore = runSizes{-1, -1}
+	d.confirmations = make(map[string]Confirmation)
+	d.drainOrder = nil
+	d.described = nil
+	if d.CloudWatchLogGroup != "" && d.logWriter == nil {
+		// Multi-cluster and ramp runs share the outer run's stream.
+		stop, err := d.startCloudWatchLogs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer stop()
+	}
+	defer func() {
+		if _, ok := errors.Cause(err).(*CredentialsExpiredError); ok && result == nil {
+			// Nothing more can be done without new credentials, but what was
+			// done so far is still worth reporting.
+			result = d.result
+		}
+		if !d.DryRun && !d.result.NoChange && len(d.Clusters) == 0 && len(d.RampSchedule) == 0 {
+			fmt.Println(d.result.Summary())
+		}
+		if err == nil && d.FailOnWarning && len(d.result.Warnings) > 0 {
+			err = fmt.Errorf("run finished with %d warnings", len(d.result.Warnings))
+		}
+		if d.HistoryTable != "" && !d.DryRun && len(d.Clusters) == 0 && len(d.RampSchedule) == 0 {
+			if historyErr := d.recordHistory(context.Background(), err); historyErr != nil {
+				d.warnf("cannot record the run in history table %s: %s", d.HistoryTable, historyErr)
+			}
+		}
+		if d.CordonListPath != "" && !d.DryRun && !d.Record && len(d.Clusters) == 0 && len(d.RampSchedule) == 0 {
+			if cordonErr := d.updateCordonList(); cordonErr != nil {
+				log.Print(cordonErr)
+				if err == nil {
+					err = cordonErr
+				}
+			}
+		}
+		if d.JUnitReportPath != "" && !d.DryRun && len(d.Clusters) == 0 && len(d.RampSchedule) == 0 {
+			if reportErr := d.writeJUnitReport(d.result, err); reportErr != nil {
+				log.Printf("Cannot write JUnit report: %s", reportErr)
+			}
+		}
+		if d.CSVReportPath != "" && len(d.Clusters) == 0 && len(d.RampSchedule) == 0 {
+			if reportErr := d.writeCSVReport(); reportErr != nil {
+				log.Printf("Cannot write CSV report: %s", reportErr)
+			}
+		}
+	}()
+
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	if d.Reason != "" && len(d.Clusters) == 0 && len(d.RampSchedule) == 0 {
+		log.Printf("Reason: %s", d.Reason)
+	}
+
+	if err := waitUntil(ctx, d.DelayStart); err != nil {
+		return nil, err
+	}
+	if err := d.checkAllowedWindows(time.Now()); err != nil {
+		return nil, err
+	}
+
+	if d.state.path != d.StatePath {
+		if d.state, err = loadRunState(d.StatePath); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(d.Clusters) > 0 {
+		return d.runClusters(ctx)
+	}
+	if len(d.RampSchedule) > 0 {
+		return d.runRamp(ctx)
+	}
+
+	if err := d.preflight(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.inferClusterAndService(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.checkKillSwitch(ctx); err != nil {
+		return nil, err
+	}
+	if d.PreflightIAMCheck {
+		if err := d.checkIAMPermissions(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if d.HistoryTable != "" {
+		d.sizesBefore = d.currentSizes(ctx)
+	}
+	if d.ScheduleS3URI != "" {
+		if err := d.applySchedule(ctx, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if d.TargetHealthyRatio != 0 {
+		if err := d.applyTargetHealthyRatio(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.checkAbsoluteMinInstances(d.instanceTarget()); err != nil {
+		return nil, err
+	}
+
+	atTarget, err := d.atTarget(ctx)
+	if err != nil {
+		return d.result, err
+	}
+	if atTarget {
+		if d.FailIfNoChange {
+			return d.result, errors.Wrap(ErrNoCandidates, "the service and ASG are already at the desired size")
+		}
+		log.Printf("No action needed: the service and ASG are already at the desired size")
+		d.result.NoChange = true
+		return d.result, nil
+	}
+
+	if err := d.checkServiceScaling(ctx); err != nil {
+		return d.result, err
+	}
+
+	if d.DryRun {
+		if activities, err := d.inProgressScalingActivities(ctx); err == nil && len(activities) > 0 {
+			d.warnf("ASG %s has %d scaling activities in progress, which a real run would wait for or fail on", d.ASG, len(activities))
+		}
+	} else if err := d.checkScalingActivities(ctx); err != nil {
+		return d.result, err
+	}
+
+	if len(d.SuspendASGProcesses) > 0 && !d.DryRun {
+		log.Printf("Suspending ASG processes: %s", strings.Join(d.SuspendASGProcesses, ", "))
+		if err := d.suspendASGProcesses(ctx, d.SuspendASGProcesses); err != nil {
+			return nil, err
+		}
+		defer func() {
+			log.Printf("Resuming ASG processes: %s", strings.Join(d.SuspendASGProcesses, ", "))
+			// Even if the run was cancelled.
+			if resumeErr := d.resumeASGProcesses(context.Background(), d.SuspendASGProcesses); resumeErr != nil {
+				log.Print(resumeErr)
+				if err == nil {
+					err = resumeErr
+				}
+			}
+		}()
+	}
+
+	restore, suspendErr := d.suspendScalableTarget(ctx)
+	if suspendErr != nil {
+		return nil, suspendErr
+	}
+	defer func() {
+		if restoreErr := restore(); restoreErr != nil {
+			log.Print(restoreErr)
+			if err == nil {
+				err = restoreErr
+			}
+		}
+	}()
 
+	d.reportProgress(PhaseDiscovering)
 	containerInstances, err := d.findDrainableContainerInstances(ctx)
+	if errors.Cause(err) == ErrNoCandidates && !d.FailIfNoChange {
+		log.Printf("Nothing to do: %s", err)
+		d.result.NoChange = true
+		return d.result, nil
+	}
 	if err != nil {
-		return err
+		return d.result, err
+	}
+
+	containerInstances = d.skipTerminated(containerInstances)
+	if d.CSVReportPath != "" {
+		if err := d.recordDrainOrder(ctx, containerInstances); err != nil {
+			return d.result, err
+		}
 	}
 
 	fmt.Printf("Found %d drainable container instances.\n", len(containerInstances))
 
 	s, err := d.ecsService(ctx)
 	if err != nil {
-		return err
+		return d.result, err
 	}
 
+	if running := aws.Int64Value(s.RunningCount); d.MaxCurrentTasks > 0 && running > d.MaxCurrentTasks {
+		return d.result, errors.Wrapf(ErrLoadTooHigh, "service %s is running %d tasks, more than the maximum of %d", d.Service, running, d.MaxCurrentTasks)
+	}
+
+	// The instance goal, ASGDesiredCount or else DesiredCount, decides how
+	// many instances get drained: findDrainableContainerInstances already
+	// returned exactly that many. The task goal, maxToRemove, is spread across
+	// the batches in proportion to their size, so ECS reaches DesiredCount
+	// tasks with the last batch even when the service doesn't run one task per
+	// instance.
 	originalTaskCount := *s.DesiredCount
 	maxToRemove := originalTaskCount - d.Config.DesiredCount
-	if maxToRemove == 0 {
-		return fmt.Errorf("Though we had %d drainable instances, no room to decrease ECS cluster size. aborting.", len(containerInstances))
+	if maxToRemove <= 0 {
+		return d.result, fmt.Errorf("Though we had %d drainable instances, no room to decrease ECS cluster size. aborting.", len(containerInstances))
+	}
+
+	if !d.SkipCapacityCheck {
+		if err := d.checkCapacity(ctx, s, containerInstances); err != nil {
+			return d.result, err
+		}
+	}
+	if err := d.checkPlacementConstraints(ctx, s, containerInstances); err != nil {
+		if !d.SkipConstraintCheck {
+			return d.result, err
+		}
+		d.warnf("%s", err)
+	}
+
+	if d.ASGDesiredCount > 0 {
+		if err := d.checkASGCanHostTasks(ctx); err != nil {
+			return d.result, err
+		}
 	}
 
-	for start := 0; start < len(containerInstances) && start < int(maxToRemove); start += d.BatchSize {
-		end := start + d.BatchSize
-		if l := len(containerInstances); end > l {
-			end = l
+	toDrain := len(containerInstances)
+	if d.BatchSize > toDrain {
+		log.Printf("Batch size %d is more than the %d container instances to drain: draining them in one batch", d.BatchSize, toDrain)
+	}
+	if d.DryRun {
+		if d.result.Plan, err = d.buildPlan(ctx, s, containerInstances, maxToRemove); err != nil {
+			return d.result, err
+		}
+		// Validate rejects any other format.
+		if d.PlanFormat == "tfplan" {
+			printTerraformPlan(d.result.Plan)
+		} else {
+			printPlan(d.result.Plan)
+		}
+		return d.result, nil
+	}
+
+	d.progress.TotalBatches = (toDrain + d.BatchSize - 1) / d.BatchSize
+	for start, end := 0, 0; start < toDrain; start = end {
+		if start > 0 {
+			if err := d.checkControlFile(ctx); err != nil {
+				return d.result, err
+			}
 		}
-		s, err = d.ScaleDown(ctx, s, containerInstances[start:end])
+		if start > 0 && d.HealthCheckURL != "" {
+			log.Printf("Checking health of %s...", d.HealthCheckURL)
+			if err := d.checkHealth(ctx); err != nil {
+				return d.result, err
+			}
+		}
+
+		var tasksToRemove int64
+		if d.ReevaluateEachBatch && start > 0 {
+			containerInstances, err = d.reevaluateCandidates(ctx, containerInstances[:start], toDrain-start)
+			if err != nil {
+				return d.result, err
+			}
+			toDrain = len(containerInstances)
+			if start >= toDrain {
+				break
+			}
+			if d.CSVReportPath != "" {
+				if err := d.recordDrainOrder(ctx, containerInstances[start:]); err != nil {
+					return d.result, err
+				}
+			}
+		}
+		end = start + d.BatchSize
+		if end > toDrain {
+			end = toDrain
+		}
+		if d.MinServiceReplicas > 0 {
+			n, err := d.replicaSafeBatchSize(ctx, s, containerInstances[start:end])
+			if err != nil {
+				return d.result, err
+			}
+			end = start + n
+		}
+		if d.MaxConcurrentDraining > 0 {
+			n, err := d.drainingRoom(ctx, end-start)
+			if err != nil {
+				return d.result, err
+			}
+			end = start + n
+		}
+		d.progress.Batch++
+		if d.StepByTaskDensity {
+			tasks, err := d.tasksOnContainerInstances(ctx, containerInstances[start:end])
+			if err != nil {
+				return d.result, err
+			}
+			running := countServiceTasks(d.Service, tasks, containerInstances[start:end])
+			tasksToRemove = tasksForDensity(running, *s.DesiredCount-d.DesiredCount, end == toDrain)
+			log.Printf("Batch %d runs %d of the service's tasks: lowering its task count by %d", d.progress.Batch, running, tasksToRemove)
+		} else if d.ReevaluateEachBatch {
+			// The plan may have changed, so spread what's left of the task
+			// goal over what's left of the instances.
+			tasksToRemove = tasksForBatch(*s.DesiredCount-d.DesiredCount, toDrain-start, 0, end-start)
+		} else {
+			tasksToRemove = tasksForBatch(maxToRemove, toDrain, start, end)
+		}
+		batchCtx, cancel := ctx, func() {}
+		if d.MaxBatchDuration > 0 {
+			batchCtx, cancel = context.WithTimeout(ctx, d.MaxBatchDuration)
+		}
+		batchStart := time.Now()
+		s, err = d.scaleDown(batchCtx, s, containerInstances[start:end], tasksToRemove)
+		cancel()
+		d.result.BatchDurations = append(d.result.BatchDurations, time.Since(batchStart))
 		if err != nil {
-			return err
+			d.result.FailedBatch = d.progress.Batch
+			if batchCtx.Err() == context.DeadlineExceeded {
+				return d.result, fmt.Errorf("batch %d took longer than %s and was stuck %s: %s", d.progress.Batch, d.MaxBatchDuration, d.progress.Phase, err)
+			}
+			return d.result, err
 		}
 	}
 
 	fmt.Println(strings.Repeat("*", 80))
 
+	d.reportProgress(PhaseFinalizing)
 	if d.Config.InstanceFlip {
 		log.Printf("Returning ECS back to original task count %d", originalTaskCount)
-		_, err = d.updateECSService(ctx, originalTaskCount)
+		if _, err := d.updateECSService(ctx, originalTaskCount, d.ForceNewDeployment); err != nil {
+			return d.result, err
+		}
+		if err := d.waitForHealthyInstances(ctx); err != nil {
+			return d.result, err
+		}
+		log.Println("Success!")
+		d.reportProgress(PhaseDone)
+		return d.result, nil
+	}
+	if d.FinalShrinkDelay > 0 && !d.Record {
+		// A last chance to abort, since the ASG's maximum size is about to change for good.
+		log.Printf("Final ASG shrink to %d in %s; Ctrl-C to abort", d.asgTarget(), d.FinalShrinkDelay)
+		if err := sleepContext(ctx, d.FinalShrinkDelay); err != nil {
+			return d.result, err
+		}
+	}
+	// Set the ASG's final min, max, and desired count, worked out after the delay in case the ASG changed.
+	finalCapacity, err := d.finalASGCapacity(ctx)
+	if err != nil {
+		return d.result, err
+	}
+	if err := d.updateASG(ctx, finalCapacity, true); err != nil {
+		return d.result, err
+	}
+	if d.VerifyASGSettle > 0 && !d.Record {
+		if err := d.verifyASGSizes(ctx, ASGSizes{DesiredCapacity: finalCapacity, MinSize: finalCapacity, MaxSize: finalCapacity}); err != nil {
+			return d.result, err
+		}
+	}
+	if err := d.waitForHealthyInstances(ctx); err != nil {
+		return d.result, err
+	}
+	d.reportProgress(PhaseDone)
+	return d.result, nil
+
+}
+
+// Finds the best candidates to drain again, after the batches already drained,
+// so a long run follows changes in the cluster. At most remaining more
+// instances are planned, so the run never drains more than it first set out to,
+// e.g. while the ASG replaces drained instances in flip mode.
+func (d *DownScaler) reevaluateCandidates(ctx context.Context, done []*string, remaining int) ([]*string, error) {
+	log.Println("Re-evaluating drain candidates...")
+	fresh, err := d.findDrainableContainerInstances(ctx)
+	if errors.Cause(err) == ErrNoCandidates {
+		log.Printf("No more container instances to drain: %s", err)
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	drained := make(map[string]bool)
+	for _, arn := range done {
+		drained[*arn] = true
+	}
+	candidates := append([]*string{}, done...)
+	for _, arn := range d.skipTerminated(fresh) {
+		if len(candidates)-len(done) == remaining {
+			break
+		}
+		if !drained[*arn] {
+			candidates = append(candidates, arn)
+		}
+	}
+	return candidates, nil
+}
+
+// Prints the batches a run would drain, without changing anything, along with
+// an estimate of how long the run would take.
+func printPlan(plan *Plan) {
+	fmt.Println(strings.Repeat("*", 80))
+	log.Println("Dry run: no changes will be made.")
+
+	for i, batch := range plan.Batches {
+		fmt.Printf("Batch %d: drain %d container instances, ECS task count to %d\n", i+1, len(batch.ContainerInstanceArns), batch.TaskCount)
+		for _, arn := range batch.ContainerInstanceArns {
+			fmt.Printf("\t%s\n", arn)
+			for _, task := range batch.Tasks {
+				if task.ContainerInstanceArn == arn {
+					fmt.Printf("\t\t%s (%s)\n", task.TaskArn, task.Group)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("%d tasks would be disrupted\n", plan.DisruptedTasks)
+	if len(plan.WarmPool) > 0 {
+		fmt.Printf("Would keep %s running in the warm pool\n", strings.Join(plan.WarmPool, ", "))
+	}
+	if plan.InsufficientCapacity != "" {
+		fmt.Printf("Insufficient capacity: %s\n", plan.InsufficientCapacity)
+	}
+
+	estimate := plan.EstimatedDuration.Round(time.Minute)
+	fmt.Printf("Estimated %d batches, ~%s (a rough estimate based on recent deployment durations)\n", len(plan.Batches), estimate)
+}
+
+// Runs the scale-down for each of the configured clusters in turn. A failing
+// cluster doesn't stop the others from running; all failures are returned
+// together once every cluster has run.
+func (d *DownScaler) runClusters(ctx context.Context) (*Result, error) {
+	result := &Result{Reason: d.Reason, Clusters: make(map[string]*Result)}
+	var failures []string
+
+	for _, spec := range d.Clusters {
+		config := *d.Config
+		config.Cluster = spec.Cluster
+		config.Service = spec.Service
+		config.ASG = spec.ASG
+		config.Clusters = nil
+		config.JUnitReportPath = reportPathFor(d.JUnitReportPath, spec.Cluster)
+		config.CSVReportPath = reportPathFor(d.CSVReportPath, spec.Cluster)
+
+		cluster := *d
+		cluster.Config = &config
+
+		fmt.Println(strings.Repeat("=", 80))
+		log.Printf("Scaling down cluster %s (service %s, ASG %s)", spec.Cluster, spec.Service, spec.ASG)
+
+		r, err := cluster.RunWithContext(ctx)
+		if r != nil {
+			result.Clusters[spec.Cluster] = r
+			result.merge(r, spec.Cluster)
+		}
 		if err != nil {
-			log.Println("Success!")
+			log.Printf("Cluster %s failed: %s", spec.Cluster, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", spec.Cluster, err))
 		}
-		return err
 	}
-	// Set the ASG's final min, max, and desired count.
-	return d.updateASG(ctx, d.DesiredCount, true)
 
+	// Every cluster's requests were recorded by the clients, which are shared.
+	result.Recorded = d.result.Recorded
+
+	if len(failures) > 0 {
+		return result, fmt.Errorf("%d of %d clusters failed:\n\t%s", len(failures), len(d.Clusters), strings.Join(failures, "\n\t"))
+	}
+	return result, nil
+}
+
+// Adds what a cluster's or ramp step's run did to the overall result, with
+// its warnings prefixed by the given name.
+func (r *Result) merge(run *Result, name string) {
+	r.Terminated = append(r.Terminated, run.Terminated...)
+	r.ForceTerminated = append(r.ForceTerminated, run.ForceTerminated...)
+	r.Instances = append(r.Instances, run.Instances...)
+	r.DrainFailures = append(r.DrainFailures, run.DrainFailures...)
+	r.Drained = append(r.Drained, run.Drained...)
+	r.WarmPool = append(r.WarmPool, run.WarmPool...)
+	r.Skipped = append(r.Skipped, run.Skipped...)
+	r.BatchDurations = append(r.BatchDurations, run.BatchDurations...)
+	for _, w := range run.Warnings {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%s: %s", name, w))
+	}
+}
+
+// Summary reconciles the instances drained, terminated and skipped, e.g.
+// "Drained 5, terminated 4, skipped 1 (1 failed to drain)".
+func (r *Result) Summary() string {
+	var reasons []string
+	counts := make(map[string]int)
+	count := func(reason string) {
+		if counts[reason] == 0 {
+			reasons = append(reasons, reason)
+		}
+		counts[reason]++
+	}
+	for _, s := range r.Skipped {
+		count(s.Reason)
+	}
+	for range r.DrainFailures {
+		count("failed to drain")
+	}
+
+	summary := fmt.Sprintf("Drained %d, terminated %d, skipped %d", len(r.Drained), len(r.Terminated), len(r.Skipped)+len(r.DrainFailures))
+	if len(reasons) > 0 {
+		details := make([]string, 0, len(reasons))
+		for _, reason := range reasons {
+			details = append(details, fmt.Sprintf("%d %s", counts[reason], reason))
+		}
+		summary += " (" + strings.Join(details, ", ") + ")"
+	}
+	return summary
+}
+
+// Logs a warning and records it on the run's result.
+func (d *DownScaler) warnf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", warning)
+	d.warningsMu.Lock()
+	defer d.warningsMu.Unlock()
+	d.result.Warnings = append(d.result.Warnings, warning)
+}
+
+// How long WaitBeforeASGShrink waits when WaitBeforeASGShrinkTimeout is zero.
+const defaultShrinkWaitTimeout = 15 * time.Minute
+
+// Returns how many of the totalTasks to remove from the service while draining
+// instances [start, end) of total. Summed over consecutive batches this
+// removes exactly totalTasks once every instance has been drained.
+func tasksForBatch(totalTasks int64, total, start, end int) int64 {
+	return totalTasks*int64(end)/int64(total) - totalTasks*int64(start)/int64(total)
+}
+
+// Returns how many tasks a batch running serviceTasks of the service's tasks
+// removes, with remaining tasks left to remove: the tasks it runs, but never
+// more than remaining, and all of remaining with the last batch.
+func tasksForDensity(serviceTasks, remaining int64, last bool) int64 {
+	if remaining < 0 {
+		return 0
+	}
+	if last || serviceTasks > remaining {
+		return remaining
+	}
+	return serviceTasks
 }
 
+// Reports whether the service is to run more tasks than the ASG has instances,
+// when the ASG should follow the task count.
+func mismatchedASG(config *Config, desiredCount, asgDesired int64) bool {
+	return len(config.InstanceWeights) == 0 && config.ASGDesiredCount <= 0 && desiredCount > asgDesired
+}
+
+// Returns the ASG's desired capacity once the batch's terminating instances,
+// which are in the ASG, are gone and the service runs desiredCount tasks.
+func (d *DownScaler) batchASGDesired(ctx context.Context, service *ecs.Service, asg *autoscaling.Group, terminating []*string, desiredCount int64) (int64, error) {
+	asgDesired := aws.Int64Value(asg.DesiredCapacity)
+	if len(d.InstanceWeights) > 0 {
+		// The ASG counts its capacity in weighted units rather than instances.
+		units, err := d.weightedCapacity(ctx, terminating)
+		if err != nil {
+			return 0, err
+		}
+		return asgDesired - units, nil
+	}
+	batchFloor := asgDesired - int64(len(terminating))
+	if d.ASGDesiredCount > 0 {
+		// The ASG is sized independently of the task count.
+		if batchFloor < d.ASGDesiredCount {
+			return d.ASGDesiredCount, nil
+		}
+		return batchFloor, nil
+	}
+	if mismatchedASG(d.Config, desiredCount, asgDesired) {
+		d.warnf("mismatched container and instance count %d != %d. but mismatch mode enabled; will reduce instances to %d", *service.DesiredCount, asgDesired, batchFloor)
+	}
+	// The ASG follows the instances left rather than the task count. With
+	// more instances than tasks, following the task count would have the ASG
+	// terminate instances beyond the batch, which weren't drained; later
+	// batches drain those instead. With more tasks than instances, it would
+	// have the ASG launch replacements for the batch, leaving an undrained
+	// instance for the final update to terminate.
+	return batchFloor, nil
+}
+
+// ScaleDown drains and terminates the given container instances, lowering the
+// service's task count by one per instance.
 func (d *DownScaler) ScaleDown(ctx context.Context, service *ecs.Service, containerInstances []*string) (*ecs.Service, error) {
-	desiredCount := *service.DesiredCount - int64(len(containerInstances))
-	instanceDesired := desiredCount
+	return d.scaleDown(ctx, service, containerInstances, int64(len(containerInstances)))
+}
+
+func (d *DownScaler) scaleDown(ctx context.Context, service *ecs.Service, containerInstances []*string, tasksToRemove int64) (*ecs.Service, error) {
+	desiredCount := *service.DesiredCount - tasksToRemove
 
+	// Only instances in the ASG count towards its capacity; orphans
+	// terminated through EC2 don't.
+	var asg *autoscaling.Group
+	inASG := make(map[string]bool)
 	if !d.Config.InstanceFlip {
-		// Figure out instance desired count
-		asg, err := d.describeASG(ctx)
+		var err error
+		if asg, err = d.describeASG(ctx); err != nil {
+			return nil, err
+		}
+		members, err := d.containerInstancesInGroup(ctx, asg, containerInstances)
 		if err != nil {
 			return nil, err
 		}
-		asgDesired := aws.Int64Value(asg.DesiredCapacity)
-		if instanceDesired > asgDesired {
-			instanceDesired = asgDesired - int64(len(containerInstances))
-			mismatch := fmt.Sprintf("mismatched container and instance count %d != %d", *service.DesiredCount, asgDesired)
-			if !d.Config.AllowASGMismatch {
-				return nil, fmt.Errorf("%s not allowed; use -allow-mismatch to allow", mismatch)
-			}
-			log.Printf("Warning: %s. but mismatch mode enabled; will reduce instances to %d", mismatch, instanceDesired)
+		for _, arn := range members {
+			inASG[*arn] = true
+		}
+		// Fail before draining anything.
+		if asgDesired := aws.Int64Value(asg.DesiredCapacity); mismatchedASG(d.Config, desiredCount, asgDesired) && !d.Config.AllowASGMismatch {
+			return nil, fmt.Errorf("mismatched container and instance count %d != %d not allowed; use -allow-mismatch to allow", *service.DesiredCount, asgDesired)
 		}
-
 	}
 
 	fmt.Println(strings.Repeat("*", 80))
 
+	if d.RespectSpread {
+		if err := d.checkSpread(ctx, service, containerInstances); err != nil {
+			return nil, err
+		}
+	}
+
 	// Drain container instances.
+	d.reportProgress(PhaseDraining)
 	log.Println("Draining container instances:")
 	for _, ci := range containerInstances {
 		fmt.Printf("\t%s\n", *ci)
 	}
-	drained, err := d.drainContainerInstances(ctx, containerInstances)
+	drained, failures, err := d.drainContainerInstances(ctx, containerInstances)
 	if err != nil {
 		return nil, err
 	}
+	d.progress.Drained += len(drained)
+	for _, ci := range drained {
+		d.result.Drained = append(d.result.Drained, aws.StringValue(ci.ContainerInstanceArn))
+	}
+
+	if len(failures) > 0 {
+		var failed []*string
+		for _, f := range failures {
+			log.Printf("Failed to drain %s: %s", f.ContainerInstanceArn, f.Reason)
+			failed = append(failed, aws.String(f.ContainerInstanceArn))
+		}
+		d.result.DrainFailures = append(d.result.DrainFailures, failures...)
+		if !d.ContinueOnError {
+			return nil, fmt.Errorf("failed to drain %d of %d container instances", len(failures), len(containerInstances))
+		}
+		d.warnf("continuing with the %d container instances that were drained", len(drained))
+
+		// Nor should the service lose the tasks of the instances that
+		// weren't drained.
+		tasksToRemove = tasksToRemove * int64(len(drained)) / int64(len(containerInstances))
+		desiredCount = *service.DesiredCount - tasksToRemove
+	}
+
+	if d.WarmPoolSize > 0 {
+		_, drained = d.keepWarm(drained)
+	}
+
+	// Size the ASG by the instances it is losing: not those that failed to
+	// drain, or are kept warm.
+	instanceDesired := desiredCount
+	if !d.Config.InstanceFlip {
+		var terminating []*string
+		for _, ci := range drained {
+			if inASG[aws.StringValue(ci.ContainerInstanceArn)] {
+				terminating = append(terminating, ci.ContainerInstanceArn)
+			}
+		}
+		if instanceDesired, err = d.batchASGDesired(ctx, service, asg, terminating, desiredCount); err != nil {
+			return nil, err
+		}
+	}
 
 	if desiredCount > 0 {
 		// Scale down ECS tasks.
-		log.Printf("Scaling down ECS task count to %d...", desiredCount)
-		service, err = d.updateECSService(ctx, desiredCount)
-		if err != nil {
-			return nil, err
+		if tasksToRemove > 0 {
+			d.reportProgress(PhaseScalingService)
+			log.Printf("Scaling down ECS task count to %d...", desiredCount)
+			service, err = d.updateECSService(ctx, desiredCount, false)
+			if err != nil {
+				return nil, err
+			}
+			if d.WaitForReschedule {
+				if service, err = d.waitForReschedule(ctx); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if !d.Config.InstanceFlip && d.WaitBeforeASGShrink && !d.Record {
+			// Don't take capacity away until ECS has moved the tasks off it.
+			timeout := d.WaitBeforeASGShrinkTimeout
+			if timeout <= 0 {
+				timeout = defaultShrinkWaitTimeout
+			}
+			log.Printf("Waiting up to %s for drained container instances to stop their tasks before shrinking the ASG...", timeout)
+			busy, err := d.waitForTasksToDrain(ctx, drained, timeout)
+			if err != nil {
+				return nil, err
+			}
+			if len(busy) > 0 {
+				var ids []string
+				for _, ci := range busy {
+					ids = append(ids, aws.StringValue(ci.Ec2InstanceId))
+				}
+				if !d.ForceAfterGrace {
+					return nil, fmt.Errorf("container instances still running tasks after %s, so the ASG wasn't shrunk: %s; raise -wait-before-asg-shrink-timeout, or shrink anyway with -force-after-grace", timeout, strings.Join(ids, ", "))
+				}
+				d.warnf("shrinking the ASG with container instances still running tasks after %s: %s", timeout, strings.Join(ids, ", "))
+			}
 		}
 
-		if !d.Config.InstanceFlip {
+		if !d.Config.InstanceFlip && d.DecrementOnTerminate {
+			// Terminating decrements the desired capacity, so only make room
+			// for it here.
+			d.reportProgress(PhaseScalingASG)
+			log.Printf("Lowering ASG minimum size to %d...\n", instanceDesired)
+			if err := d.updateASGMinSize(ctx, instanceDesired); err != nil {
+				return nil, err
+			}
+		} else if !d.Config.InstanceFlip {
+			d.reportProgress(PhaseScalingASG)
 			log.Printf("Scaling down ASG instance count to %d...\n", instanceDesired)
 			if err := d.updateASG(ctx, instanceDesired, false); err != nil {
 				return nil, err
@@ -147,7 +1401,61 @@ func (d *DownScaler) ScaleDown(ctx context.Context, service *ecs.Service, contai
 		}
 	}
 
+	if d.DrainGracePeriod > 0 && !d.Record {
+		d.reportProgress(PhaseWaitingForDrain)
+		log.Printf("Waiting up to %s for drained container instances to stop their tasks...", d.DrainGracePeriod)
+		busy, err := d.waitForTasksToDrain(ctx, drained, d.DrainGracePeriod)
+		if err != nil {
+			return nil, err
+		}
+		if len(busy) > 0 {
+			var ids []string
+			for _, ci := range busy {
+				ids = append(ids, aws.StringValue(ci.Ec2InstanceId))
+			}
+			if !d.ForceAfterGrace {
+				return nil, fmt.Errorf("container instances still running tasks after %s: %s", d.DrainGracePeriod, strings.Join(ids, ", "))
+			}
+			d.warnf("force-terminating container instances still running tasks after %s: %s", d.DrainGracePeriod, strings.Join(ids, ", "))
+			d.result.ForceTerminated = append(d.result.ForceTerminated, ids...)
+		}
+	}
+
+	delay := d.DrainToTerminateDelay
+	if delay == 0 {
+		// Give the tasks the time they declared they need to shut down.
+		if delay, err = d.taskStopTimeout(ctx, service); err != nil {
+			return nil, err
+		}
+	}
+	if delay > 0 && !d.Record {
+		log.Printf("Waiting %s before terminating drained container instances...", delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.SQSQueueURL != "" && !d.Record {
+		if err := d.waitForQueueToSettle(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.StopReason != "" {
+		log.Printf("Stopping tasks still running on drained container instances with reason %q:", d.StopReason)
+		if err := d.stopRemainingTasks(ctx, drained); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.WarnOnInstanceStore {
+		if err := d.warnAboutInstanceStore(ctx, drained); err != nil {
+			return nil, err
+		}
+	}
+
 	// Terminate drained instances.
+	d.reportProgress(PhaseTerminating)
 	log.Println("Terminating container instances:")
 	for _, ci := range drained {
 		fmt.Printf("\t%s\n", *ci.Ec2InstanceId)
@@ -156,5 +1464,63 @@ func (d *DownScaler) ScaleDown(ctx context.Context, service *ecs.Service, contai
 		return nil, err
 	}
 
+	if d.DeregisterContainerInstances {
+		log.Println("Deregistering container instances:")
+		if err := d.deregisterContainerInstances(ctx, drained); err != nil {
+			return nil, err
+		}
+	}
+
 	return service, nil
 }
+
+// Removes container instances that were already terminated by a previous run.
+func (d *DownScaler) skipTerminated(containerInstances []*string) []*string {
+	var remaining []*string
+	for _, arn := range containerInstances {
+		if d.state.isContainerInstanceTerminated(*arn) {
+			log.Printf("Skipping %s: already terminated by a previous run", *arn)
+			d.result.Skipped = append(d.result.Skipped, SkippedInstance{ContainerInstanceArn: *arn, Reason: SkipAlreadyTerminated})
+			continue
+		}
+		remaining = append(remaining, arn)
+	}
+	return remaining
+}
+
+// How often a delayed run logs how long it has left to wait.
+const delayLogInterval = 10 * time.Minute
+
+// Waits until the given time, if it's in the future, logging every so often.
+func waitUntil(ctx context.Context, start time.Time) error {
+	if !time.Now().Before(start) {
+		return nil
+	}
+	log.Printf("Waiting until %s to start; Ctrl-C to cancel", start.Format(time.RFC3339))
+	for {
+		left := time.Until(start)
+		if left <= 0 {
+			return nil
+		}
+		wait := left
+		if wait > delayLogInterval {
+			wait = delayLogInterval
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+		if left > wait {
+			log.Printf("Starting in %s", (left - wait).Round(time.Second))
+		}
+	}
+}
+
+// Sleeps for the given duration, returning early with an error if the context is done first.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
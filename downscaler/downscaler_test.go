@@ -0,0 +1,436 @@
+package downscaler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestScaleDownASGDesiredCapacity(t *testing.T) {
+	tests := []struct {
+		name                 string
+		instances            int
+		tasks                int64
+		decrementOnTerminate bool
+		batch                []string
+		tasksToRemove        int64
+		wantDesired          int64
+		wantMin              int64
+	}{
+		{
+			name:          "set by the update",
+			instances:     4,
+			tasks:         4,
+			batch:         []string{"i-01", "i-02"},
+			tasksToRemove: 2,
+			wantDesired:   2,
+			wantMin:       2,
+		},
+		{
+			name:                 "decremented on terminate",
+			instances:            4,
+			tasks:                4,
+			decrementOnTerminate: true,
+			batch:                []string{"i-01", "i-02"},
+			tasksToRemove:        2,
+			wantDesired:          2,
+			wantMin:              2,
+		},
+		{
+			name:          "more instances than tasks, set by the update",
+			instances:     6,
+			tasks:         4,
+			batch:         []string{"i-01", "i-02"},
+			tasksToRemove: 2,
+			wantDesired:   4,
+			wantMin:       4,
+		},
+		{
+			name:                 "more instances than tasks, decremented on terminate",
+			instances:            6,
+			tasks:                4,
+			decrementOnTerminate: true,
+			batch:                []string{"i-01", "i-02"},
+			tasksToRemove:        2,
+			wantDesired:          4,
+			wantMin:              4,
+		},
+		{
+			name:          "more tasks than instances, set by the update",
+			instances:     4,
+			tasks:         5,
+			batch:         []string{"i-01"},
+			tasksToRemove: 1,
+			wantDesired:   3,
+			wantMin:       3,
+		},
+		{
+			name:                 "more tasks than instances, decremented on terminate",
+			instances:            4,
+			tasks:                5,
+			decrementOnTerminate: true,
+			batch:                []string{"i-01"},
+			tasksToRemove:        1,
+			wantDesired:          3,
+			wantMin:              3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := newFakeCluster(test.instances)
+			cluster.desiredCount = test.tasks
+			config := cluster.config()
+			config.DecrementOnTerminate = test.decrementOnTerminate
+			d, fake := newTestDownScaler(t, config, cluster.handlers())
+
+			_, err := d.scaleDown(context.Background(), cluster.service(), fakeContainerArns(test.batch...), test.tasksToRemove)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cluster.asgDesired != test.wantDesired || cluster.asgMin != test.wantMin {
+				t.Errorf("ASG desired %d, min %d; want desired %d, min %d", cluster.asgDesired, cluster.asgMin, test.wantDesired, test.wantMin)
+			}
+			if got := int64(len(cluster.instances)); got != test.wantDesired {
+				t.Errorf("%d instances left in the ASG; want %d", got, test.wantDesired)
+			}
+			for _, input := range fake.inputs("UpdateAutoScalingGroup") {
+				in := input.(*autoscaling.UpdateAutoScalingGroupInput)
+				if test.decrementOnTerminate && in.DesiredCapacity != nil {
+					t.Errorf("desired capacity set to %d as well as decremented on terminate", *in.DesiredCapacity)
+				}
+			}
+			for _, input := range fake.inputs("TerminateInstanceInAutoScalingGroup") {
+				in := input.(*autoscaling.TerminateInstanceInAutoScalingGroupInput)
+				if *in.ShouldDecrementDesiredCapacity != test.decrementOnTerminate {
+					t.Errorf("%s terminated with ShouldDecrementDesiredCapacity %t; want %t", *in.InstanceId, *in.ShouldDecrementDesiredCapacity, test.decrementOnTerminate)
+				}
+			}
+		})
+	}
+}
+
+func TestRunBatchSizeAtLeastCandidates(t *testing.T) {
+	tests := []struct {
+		name         string
+		instances    int
+		desiredCount int64
+		batchSize    int
+	}{
+		{name: "batch size equals the candidates", instances: 10, desiredCount: 8, batchSize: 2},
+		{name: "batch size exceeds the candidates", instances: 10, desiredCount: 8, batchSize: 5},
+		{name: "batch size exceeds the instances", instances: 4, desiredCount: 3, batchSize: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := newFakeCluster(test.instances)
+			config := cluster.config()
+			config.DesiredCount = test.desiredCount
+			config.BatchSize = test.batchSize
+			d, fake := newTestDownScaler(t, config, cluster.handlers())
+
+			if _, err := d.Run(); err != nil {
+				t.Fatal(err)
+			}
+			maxToRemove := int64(test.instances) - test.desiredCount
+			if drained := int64(len(cluster.drained)); drained > maxToRemove {
+				t.Errorf("drained %d instances; want no more than %d", drained, maxToRemove)
+			}
+			if batches := len(fake.inputs("UpdateContainerInstancesState")); batches != 1 {
+				t.Errorf("drained in %d batches; want 1", batches)
+			}
+			if cluster.desiredCount != test.desiredCount {
+				t.Errorf("service scaled to %d tasks; want %d", cluster.desiredCount, test.desiredCount)
+			}
+			if cluster.asgDesired != test.desiredCount || int64(len(cluster.instances)) != test.desiredCount {
+				t.Errorf("ASG desired %d with %d instances; want %d", cluster.asgDesired, len(cluster.instances), test.desiredCount)
+			}
+		})
+	}
+}
+
+// With more instances than tasks, each batch removes fewer tasks than it
+// drains instances, so the ASG must not follow the task count down.
+func TestRunMoreInstancesThanTasks(t *testing.T) {
+	cluster := newFakeCluster(6)
+	cluster.desiredCount = 4
+	config := cluster.config()
+	config.DesiredCount = 2
+	config.BatchSize = 2
+	d, _ := newTestDownScaler(t, config, cluster.handlers())
+
+	if _, err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cluster.undrainedCuts) > 0 {
+		t.Errorf("ASG set to desired capacities %d, below its undrained instances", cluster.undrainedCuts)
+	}
+	if cluster.desiredCount != 2 {
+		t.Errorf("service scaled to %d tasks; want 2", cluster.desiredCount)
+	}
+	if cluster.asgDesired != 2 || len(cluster.instances) != 2 {
+		t.Errorf("ASG desired %d with %d instances; want 2", cluster.asgDesired, len(cluster.instances))
+	}
+}
+
+func TestScaleDownDrainFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		continueOnError bool
+		wantErr         string
+		wantTasks       int64
+		wantInstances   []string
+	}{
+		{
+			name:          "abort",
+			wantErr:       "failed to drain 1 of 2 container instances",
+			wantTasks:     4,
+			wantInstances: []string{"i-01", "i-02", "i-03", "i-04"},
+		},
+		{
+			name:            "continue with the drained",
+			continueOnError: true,
+			wantTasks:       3,
+			wantInstances:   []string{"i-02", "i-03", "i-04"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := newFakeCluster(4)
+			cluster.drainFailures["i-02"] = "INACTIVE"
+			config := cluster.config()
+			config.ContinueOnError = test.continueOnError
+			d, _ := newTestDownScaler(t, config, cluster.handlers())
+
+			_, err := d.scaleDown(context.Background(), cluster.service(), fakeContainerArns("i-01", "i-02"), 2)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("got error %v; want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			failures := d.result.DrainFailures
+			if len(failures) != 1 || failures[0].ContainerInstanceArn != fakeContainerArn("i-02") || failures[0].Reason != "INACTIVE" {
+				t.Errorf("drain failures %+v; want i-02's", failures)
+			}
+			if cluster.desiredCount != test.wantTasks {
+				t.Errorf("service scaled to %d tasks; want %d", cluster.desiredCount, test.wantTasks)
+			}
+			if !sameStrings(cluster.instances, test.wantInstances) {
+				t.Errorf("instances %q left; want %q", cluster.instances, test.wantInstances)
+			}
+			if want := int64(len(test.wantInstances)); cluster.asgDesired != want {
+				t.Errorf("ASG desired %d; want %d", cluster.asgDesired, want)
+			}
+		})
+	}
+}
+
+// An interrupted run still resumes the ASG's processes and the service's
+// scaling, which it suspended.
+func TestRunWithContextCancelledResumes(t *testing.T) {
+	cluster := newFakeCluster(4)
+	config := cluster.config()
+	config.DesiredCount = 2
+	config.SuspendASGProcesses = []string{"Launch"}
+	config.SuspendScalableTarget = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handlers := cluster.handlers()
+	drain := handlers["UpdateContainerInstancesState"]
+	handlers["UpdateContainerInstancesState"] = func(input interface{}) (interface{}, error) {
+		// Ctrl-C while draining.
+		cancel()
+		return drain(input)
+	}
+	handlers["DescribeScalableTargets"] = func(interface{}) (interface{}, error) {
+		return &applicationautoscaling.DescribeScalableTargetsOutput{
+			ScalableTargets: []*applicationautoscaling.ScalableTarget{{MinCapacity: aws.Int64(1), MaxCapacity: aws.Int64(10)}},
+		}, nil
+	}
+	handlers["RegisterScalableTarget"] = func(interface{}) (interface{}, error) {
+		return &applicationautoscaling.RegisterScalableTargetOutput{}, nil
+	}
+	handlers["SuspendProcesses"] = func(interface{}) (interface{}, error) {
+		return &autoscaling.SuspendProcessesOutput{}, nil
+	}
+	handlers["ResumeProcesses"] = func(interface{}) (interface{}, error) {
+		return &autoscaling.ResumeProcessesOutput{}, nil
+	}
+	d, fake := newTestDownScaler(t, config, handlers)
+
+	if _, err := d.RunWithContext(ctx); err == nil {
+		t.Fatal("interrupted run succeeded")
+	}
+	if resumed := len(fake.inputs("ResumeProcesses")); resumed != 1 {
+		t.Errorf("ASG processes resumed %d times; want once", resumed)
+	}
+	registered := fake.inputs("RegisterScalableTarget")
+	if len(registered) != 2 {
+		t.Fatalf("scalable target registered %d times; want twice", len(registered))
+	}
+	restored := registered[1].(*applicationautoscaling.RegisterScalableTargetInput).SuspendedState
+	if *restored.DynamicScalingInSuspended || *restored.DynamicScalingOutSuspended || *restored.ScheduledScalingSuspended {
+		t.Errorf("scalable target left suspended: %s", restored)
+	}
+}
+
+func TestWaitUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	done := make(chan error)
+	go func() {
+		done <- waitUntil(ctx, time.Now().Add(time.Hour))
+	}()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitUntil kept waiting after its context was cancelled")
+	}
+}
+
+// The instance goal decides how many instances are drained, and the task
+// goal is spread over their batches.
+func TestTasksForBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		totalTasks int64
+		instances  int
+		batchSize  int
+		want       []int64
+	}{
+		{name: "one task per instance", totalTasks: 4, instances: 4, batchSize: 2, want: []int64{2, 2}},
+		{name: "uneven across batches", totalTasks: 5, instances: 6, batchSize: 2, want: []int64{1, 2, 2}},
+		{name: "short last batch", totalTasks: 7, instances: 5, batchSize: 2, want: []int64{2, 3, 2}},
+		{name: "fewer tasks than instances", totalTasks: 2, instances: 6, batchSize: 1, want: []int64{0, 0, 1, 0, 0, 1}},
+		{name: "more tasks than instances", totalTasks: 10, instances: 4, batchSize: 1, want: []int64{2, 3, 2, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int64
+			var removed int64
+			for start := 0; start < test.instances; start += test.batchSize {
+				end := start + test.batchSize
+				if end > test.instances {
+					end = test.instances
+				}
+				n := tasksForBatch(test.totalTasks, test.instances, start, end)
+				got = append(got, n)
+				removed += n
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("batches remove %d tasks; want %d", got, test.want)
+			}
+			// The last batch lands exactly on DesiredCount.
+			if removed != test.totalTasks {
+				t.Errorf("removed %d tasks in all; want %d", removed, test.totalTasks)
+			}
+		})
+	}
+}
+
+func TestTasksForDensity(t *testing.T) {
+	tests := []struct {
+		name         string
+		serviceTasks int64
+		remaining    int64
+		last         bool
+		want         int64
+	}{
+		{name: "the tasks the batch runs", serviceTasks: 3, remaining: 10, want: 3},
+		{name: "no more than remaining", serviceTasks: 3, remaining: 2, want: 2},
+		{name: "last batch lands on DesiredCount", serviceTasks: 3, remaining: 5, last: true, want: 5},
+		{name: "last batch running more than remaining", serviceTasks: 6, remaining: 5, last: true, want: 5},
+		{name: "already below DesiredCount", serviceTasks: 3, remaining: -1, want: 0},
+	}
+	for _, test := range tests {
+		if got := tasksForDensity(test.serviceTasks, test.remaining, test.last); got != test.want {
+			t.Errorf("%s: tasksForDensity(%d, %d, %t) = %d; want %d", test.name, test.serviceTasks, test.remaining, test.last, got, test.want)
+		}
+	}
+}
+
+// With more tasks than instances, the ASG must not be set above the
+// instances not yet drained, or it launches replacements for them.
+func TestRunMoreTasksThanInstances(t *testing.T) {
+	cluster := newFakeCluster(4)
+	cluster.desiredCount = 5
+	config := cluster.config()
+	config.DesiredCount = 2
+	handlers := cluster.handlers()
+	update := handlers["UpdateAutoScalingGroup"]
+	var overshoots []int64
+	handlers["UpdateAutoScalingGroup"] = func(input interface{}) (interface{}, error) {
+		if desired := input.(*autoscaling.UpdateAutoScalingGroupInput).DesiredCapacity; desired != nil {
+			undrained := 0
+			for _, id := range cluster.instances {
+				if !cluster.isDrained(id) {
+					undrained++
+				}
+			}
+			if *desired > int64(undrained) {
+				overshoots = append(overshoots, *desired)
+			}
+		}
+		return update(input)
+	}
+	d, _ := newTestDownScaler(t, config, handlers)
+
+	if _, err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(overshoots) > 0 {
+		t.Errorf("ASG set to desired capacities %d, above its undrained instances", overshoots)
+	}
+	if cluster.desiredCount != 2 {
+		t.Errorf("service scaled to %d tasks; want 2", cluster.desiredCount)
+	}
+	if cluster.asgDesired != 2 || len(cluster.instances) != 2 {
+		t.Errorf("ASG desired %d with %d instances; want 2", cluster.asgDesired, len(cluster.instances))
+	}
+}
+
+// Multi-cluster and ramp runs merge every per-run list into the overall
+// result, so a list added to Result must be merged too.
+func TestResultMerge(t *testing.T) {
+	run := &Result{}
+	v := reflect.ValueOf(run).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.Kind() == reflect.Slice {
+			field.Set(reflect.MakeSlice(field.Type(), 1, 1))
+		}
+	}
+	run.Warnings = []string{"slow"}
+
+	result := &Result{}
+	result.merge(run, "cluster")
+	merged := reflect.ValueOf(result).Elem()
+	for i := 0; i < merged.NumField(); i++ {
+		name := merged.Type().Field(i).Name
+		switch name {
+		case "Recorded", "RampSteps":
+			// Recorded by the shared clients, and kept per step.
+			continue
+		}
+		if field := merged.Field(i); field.Kind() == reflect.Slice && field.Len() != 1 {
+			t.Errorf("%s has %d entries after merging; want 1", name, field.Len())
+		}
+	}
+	if want := []string{"cluster: slow"}; !reflect.DeepEqual(result.Warnings, want) {
+		t.Errorf("warnings %q; want %q", result.Warnings, want)
+	}
+}
@@ -0,0 +1,93 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Drift compares a cluster's current size with its targets.
+type Drift struct {
+	// The service's desired task count now, and its target, DesiredCount.
+	TaskCount  int64 `json:"task_count"`
+	TaskTarget int64 `json:"task_target"`
+
+	// The ASG's desired capacity and the number of ACTIVE container
+	// instances now, and their target, ASGDesiredCount or else DesiredCount.
+	ASGCapacity    int64 `json:"asg_capacity"`
+	Instances      int64 `json:"instances"`
+	InstanceTarget int64 `json:"instance_target"`
+
+	// The ASG's target, which also counts a full warm pool.
+	ASGTarget int64 `json:"asg_target"`
+
+	// How each size differs from its target. Empty when nothing drifted.
+	Differences []string `json:"differences"`
+}
+
+// Drifted reports whether any size differs from its target.
+func (d *Drift) Drifted() bool {
+	return len(d.Differences) > 0
+}
+
+// CheckDrift compares the service's desired count, the ASG's desired capacity
+// and the number of ACTIVE container instances with their targets, without
+// changing anything.
+func (d *DownScaler) CheckDrift() (*Drift, error) {
+	ctx := context.Background()
+	if len(d.Clusters) > 0 {
+		return nil, fmt.Errorf("drift can only be checked for one cluster at a time")
+	}
+	if err := d.inferClusterAndService(ctx); err != nil {
+		return nil, err
+	}
+
+	service, err := d.ecsService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return nil, err
+	}
+	arns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+	warm, err := d.warmInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &Drift{
+		TaskCount:      aws.Int64Value(service.DesiredCount),
+		TaskTarget:     d.DesiredCount,
+		ASGCapacity:    aws.Int64Value(group.DesiredCapacity),
+		InstanceTarget: d.instanceTarget(),
+		ASGTarget:      d.instanceTarget() + warm,
+	}
+	for _, ci := range instances {
+		if aws.StringValue(ci.Status) == "ACTIVE" {
+			drift.Instances++
+		}
+	}
+
+	for _, size := range []struct {
+		name           string
+		actual, target int64
+	}{
+		{"service " + d.Service + " desired count", drift.TaskCount, drift.TaskTarget},
+		{"ASG " + d.ASG + " desired capacity", drift.ASGCapacity, drift.ASGTarget},
+		{"ACTIVE container instances in " + d.Cluster, drift.Instances, drift.InstanceTarget},
+	} {
+		if size.actual != size.target {
+			drift.Differences = append(drift.Differences, fmt.Sprintf("%s is %d, expected %d (%+d)", size.name, size.actual, size.target, size.actual-size.target))
+		}
+	}
+	return drift, nil
+}
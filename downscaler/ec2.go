@@ -0,0 +1,115 @@
+package downscaler
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// Describes the EC2 instances backing the given container instances, keyed by
+// container instance ARN.
+func (d *DownScaler) describeContainerEC2Instances(ctx context.Context, containerArns []*string) (map[string]*ec2.Instance, error) {
+	ec2IDToContainerArn := make(map[string]string)
+	var ec2IDs []string
+
+	instances, err := d.describeContainerInstances(ctx, containerArns)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		ec2ID := aws.StringValue(instance.Ec2InstanceId)
+		ec2IDToContainerArn[ec2ID] = aws.StringValue(instance.ContainerInstanceArn)
+		ec2IDs = append(ec2IDs, ec2ID)
+	}
+
+	containerArnToInstance := make(map[string]*ec2.Instance)
+	fn := func(page *ec2.DescribeInstancesOutput, hasNext bool) bool {
+		for _, res := range page.Reservations {
+			for _, instance := range res.Instances {
+				containerArn := ec2IDToContainerArn[aws.StringValue(instance.InstanceId)]
+				containerArnToInstance[containerArn] = instance
+			}
+		}
+		return page.NextToken != nil
+	}
+
+	for _, instanceIDs := range paginateStringArray(ec2IDs, 200) {
+		err := d.ec2.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice(instanceIDs),
+		}, fn)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot describe instances")
+		}
+	}
+	return containerArnToInstance, nil
+}
+
+// Reports whether the instance has instance store volumes. Our SDK can't
+// describe instance types, so this goes by the root device type and the
+// instance type's name: storage optimized families (d, h, and i but not inf),
+// and families with a "d" for local NVMe storage, e.g. m5d or c6gd.
+func hasInstanceStore(instance *ec2.Instance) bool {
+	if aws.StringValue(instance.RootDeviceType) == ec2.DeviceTypeInstanceStore {
+		return true
+	}
+	family := strings.SplitN(aws.StringValue(instance.InstanceType), ".", 2)[0]
+	if strings.IndexAny(family, "dhi") == 0 && !strings.HasPrefix(family, "inf") {
+		return true
+	}
+	// The letters after the generation number, e.g. "gd" of "c6gd".
+	if i := strings.IndexAny(family, "0123456789"); i >= 0 {
+		return strings.Contains(strings.TrimLeft(family[i:], "0123456789"), "d")
+	}
+	return false
+}
+
+// Warns about each of the container instances whose EC2 instance has
+// instance store volumes, before they're terminated regardless.
+func (d *DownScaler) warnAboutInstanceStore(ctx context.Context, containerInstances []*ecs.ContainerInstance) error {
+	arns := make([]*string, 0, len(containerInstances))
+	for _, ci := range containerInstances {
+		arns = append(arns, ci.ContainerInstanceArn)
+	}
+	instances, err := d.describeContainerEC2Instances(ctx, arns)
+	if err != nil {
+		return err
+	}
+	for _, ci := range containerInstances {
+		instance, ok := instances[aws.StringValue(ci.ContainerInstanceArn)]
+		if !ok {
+			continue
+		}
+		if hasInstanceStore(instance) {
+			d.warnf("%s (%s) has instance store volumes, whose data is lost when it's terminated; terminating it anyway", aws.StringValue(instance.InstanceId), aws.StringValue(instance.InstanceType))
+		} else {
+			log.Printf("%s (%s) has no instance store volumes", aws.StringValue(instance.InstanceId), aws.StringValue(instance.InstanceType))
+		}
+	}
+	return nil
+}
+
+func (d *DownScaler) terminatedInstance(ci *ecs.ContainerInstance, instance *ec2.Instance) TerminatedInstance {
+	arn := aws.StringValue(ci.ContainerInstanceArn)
+	reason, ok := d.selectionReasons[arn]
+	if !ok {
+		reason = "unknown"
+	}
+	terminated := TerminatedInstance{
+		ContainerInstanceArn: arn,
+		EC2InstanceID:        aws.StringValue(ci.Ec2InstanceId),
+		Reason:               reason,
+	}
+	if instance != nil {
+		terminated.InstanceType = aws.StringValue(instance.InstanceType)
+		terminated.LaunchTime = aws.TimeValue(instance.LaunchTime)
+		if instance.Placement != nil {
+			terminated.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+	}
+	return terminated
+}
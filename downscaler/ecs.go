@@ -4,15 +4,30 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"sort"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/pkg/errors"
 )
 
+// ErrNoCandidates is returned when the cluster is already at or below the
+// desired number of container instances, so there is nothing to drain.
+var ErrNoCandidates = errors.New("no container instances to drain")
+
+// ErrLoadTooHigh is returned when the service is running more tasks than
+// MaxCurrentTasks allows scaling down from.
+var ErrLoadTooHigh = errors.New("load too high to scale down")
+
+// How often to check whether draining container instances have stopped their tasks.
+const drainPollInterval = 15 * time.Second
+
 // Returns the number of tasks that can be running on a container instance
 // before it is eligible for draining.
 //
@@ -26,11 +41,15 @@ func (d *DownScaler) drainAtTaskCount(ctx context.Context) (int64, error) {
 		Clusters: []*string{&d.Cluster},
 	})
 	if err != nil {
-		return -1, nil
+		return -1, errors.Wrap(err, "cannot describe cluster")
 	}
 
 	if length := len(out.Clusters); length != 1 {
-		return -1, fmt.Errorf("expected 1 cluster named %q, but found %d", d.Cluster, length)
+		var matches []string
+		for _, cluster := range out.Clusters {
+			matches = append(matches, aws.StringValue(cluster.ClusterName))
+		}
+		return -1, d.clusterNotFound(ctx, matches)
 	}
 
 	count := out.Clusters[0].ActiveServicesCount
@@ -46,19 +65,30 @@ func (d *DownScaler) ecsService(ctx context.Context) (*ecs.Service, error) {
 		Cluster:  &d.Cluster,
 		Services: []*string{&d.Service},
 	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecs.ErrCodeClusterNotFoundException {
+		return nil, d.clusterNotFound(ctx, nil)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	if length := len(out.Services); length != 1 {
-		return nil, fmt.Errorf("expected 1 service named %q, but found %d", d.Service, length)
+		var matches []string
+		for _, service := range out.Services {
+			matches = append(matches, aws.StringValue(service.ServiceName))
+		}
+		return nil, d.serviceNotFound(ctx, matches)
 	}
 
 	return out.Services[0], nil
 }
 
-func (d *DownScaler) updateECSService(ctx context.Context, desiredCount int64) (*ecs.Service, error) {
-	forceNewDeployment := false
+func (d *DownScaler) updateECSService(ctx context.Context, desiredCount int64, forceNewDeployment bool) (*ecs.Service, error) {
+	if d.keepTaskCount {
+		log.Printf("Leaving %s's task count alone", d.Service)
+		return d.ecsService(ctx)
+	}
+	updated := time.Now()
 	out, err := d.ecs.UpdateServiceWithContext(ctx, &ecs.UpdateServiceInput{
 		Cluster:            &d.Cluster,
 		Service:            &d.Service,
@@ -68,112 +98,706 @@ func (d *DownScaler) updateECSService(ctx context.Context, desiredCount int64) (
 	if err != nil {
 		return nil, err
 	}
+	if d.SkipStableWait {
+		return out.Service, nil
+	}
 
-	err = d.ecs.WaitUntilServicesStableWithContext(ctx, &ecs.DescribeServicesInput{
-		Cluster:  &d.Cluster,
-		Services: []*string{&d.Service},
-	})
-	if err != nil {
+	if err := d.waitUntilServiceStable(ctx, updated); err != nil {
 		return nil, err
 	}
 
 	return out.Service, nil
 }
 
+// Sets DesiredCount to TargetHealthyRatio of the service's running tasks.
+func (d *DownScaler) applyTargetHealthyRatio(ctx context.Context) error {
+	service, err := d.ecsService(ctx)
+	if err != nil {
+		return err
+	}
+	running := aws.Int64Value(service.RunningCount)
+	desired := int64(math.Ceil(float64(running) * d.TargetHealthyRatio))
+	if desired >= running {
+		return fmt.Errorf("%g of %s's %d running tasks is %d, which wouldn't scale it down", d.TargetHealthyRatio, d.Service, running, desired)
+	}
+	log.Printf("Scaling down to %d tasks, %g of the %d running", desired, d.TargetHealthyRatio, running)
+	d.DesiredCount = desired
+	return nil
+}
+
+// Checks how the service can be scaled. Replica services with the ECS
+// deployment controller are updated as usual. CODE_DEPLOY services are
+// refused, since their tasks belong to task sets CodeDeploy manages and
+// updating the service would fight it. EXTERNAL services only have their
+// instances drained and the ASG resized, leaving the task count to the
+// external controller, as do DAEMON services, whose tasks follow the
+// instances and which can't be given a desired count.
+func (d *DownScaler) checkServiceScaling(ctx context.Context) error {
+	service, err := d.ecsService(ctx)
+	if err != nil {
+		return err
+	}
+	d.keepTaskCount = false
+	if aws.StringValue(service.SchedulingStrategy) == ecs.SchedulingStrategyDaemon {
+		log.Printf("Service %s is a DAEMON service: draining instances and resizing the ASG only, since its tasks follow the instances", d.Service)
+		d.keepTaskCount = true
+	}
+
+	controller := ecs.DeploymentControllerTypeEcs
+	if service.DeploymentController != nil {
+		controller = aws.StringValue(service.DeploymentController.Type)
+	}
+
+	switch controller {
+	case ecs.DeploymentControllerTypeEcs:
+		return nil
+	case ecs.DeploymentControllerTypeExternal:
+		log.Printf("Service %s uses an EXTERNAL deployment controller: draining instances and resizing the ASG only, without updating the service", d.Service)
+		d.keepTaskCount = true
+		return nil
+	case ecs.DeploymentControllerTypeCodeDeploy:
+		return fmt.Errorf("service %s is deployed by CodeDeploy, which manages its tasks; scale it through CodeDeploy instead", d.Service)
+	default:
+		return fmt.Errorf("service %s uses the %s deployment controller, which isn't supported", d.Service, controller)
+	}
+}
+
+// How far back PreferFailedTaskHosts looks for failed tasks by default. ECS
+// only keeps stopped tasks for about an hour anyway.
+const defaultFailedTaskLookback = time.Hour
+
 // Returns a list of container instance ARNs, sorted by order of preference, for draining.
 // https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DeregisterContainerInstance.html
 func (d *DownScaler) findDrainableContainerInstances(ctx context.Context) ([]*string, error) {
 	var allArns []*string
 	seen := make(map[string]bool)
-	skipped := 0
-	input := &ecs.ListContainerInstancesInput{
-		Cluster: &d.Cluster,
-	}
+	d.selectionReasons = make(map[string]string)
 
-	findInstances := func(filter string) error {
+	addInstances := func(label string, candidates []*string) error {
 		var arns []*string
-		fn := func(page *ecs.ListContainerInstancesOutput, isLastPage bool) bool {
-			for _, arnPtr := range page.ContainerInstanceArns {
-				if !seen[*arnPtr] {
-					seen[*arnPtr] = true
-					arns = append(arns, arnPtr)
-				} else {
-					skipped += 1
-				}
+		skipped := 0
+		d.explainf("filter %q matched %d instances", selectionReason(label), len(candidates))
+		for _, arnPtr := range candidates {
+			if !seen[*arnPtr] {
+				seen[*arnPtr] = true
+				d.selectionReasons[*arnPtr] = selectionReason(label)
+				arns = append(arns, arnPtr)
+			} else {
+				skipped += 1
+				d.explainf("\t%s already matched %q; not added again", *arnPtr, d.selectionReasons[*arnPtr])
 			}
-			return page.NextToken != nil
 		}
+		fmt.Printf(" -> %s: Added %d instances (%d duplicates skipped) to candidates\n", label, len(arns), skipped)
 
-		skipped = 0
-		initialArns := len(arns)
-		if filter == "" {
-			input.Filter = nil
-		} else {
-			input.Filter = aws.String(filter)
-		}
-		err := d.ecs.ListContainerInstancesPagesWithContext(ctx, input, fn)
-		if err != nil {
-			return err
-		}
-		fmt.Printf(" -> %s: Added %d instances (%d duplicates skipped) to candidates\n", filter, len(arns)-initialArns, skipped)
-
-		if d.SortByAge && len(arns) > 1 {
+		if (d.SortByAge || d.LIFO) && len(arns) > 1 {
+			var err error
 			arns, err = d.sortECSContainersByInstanceAge(ctx, arns)
 			if err != nil {
 				return err
 			}
+			order := "oldest"
+			if d.LIFO {
+				order = "newest"
+			}
+			d.explainf("\tsorted the %d instances added by launch time, %s first", len(arns), order)
 		}
 		allArns = append(allArns, arns...)
 		return nil
 	}
 
-	// Container instances with old agent are first-pick
+	// Each pass finds one priority group of candidates, highest priority first.
+	var passes []discoveryPass
+	listPass := func(filter string) discoveryPass {
+		return discoveryPass{label: filter, find: func() ([]*string, error) {
+			return d.listContainerInstances(ctx, filter)
+		}}
+	}
+
+	// Cordoned container instances were marked for retirement, so they go before anything else.
+	if d.Config.CordonListPath != "" {
+		passes = append(passes, discoveryPass{label: "cordoned", find: func() ([]*string, error) {
+			return d.findCordonedContainerInstances(ctx)
+		}})
+	}
+
+	// Container instances hosting unhealthy load balancer targets are degraded, so they go next.
+	if d.Config.PreferUnhealthy {
+		passes = append(passes, discoveryPass{label: "targetHealth == unhealthy", find: func() ([]*string, error) {
+			arns, err := d.findContainerInstancesHostingUnhealthyTargets(ctx)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("Found %d instances hosting unhealthy targets\n", len(arns))
+			return arns, nil
+		}})
+	}
+
+	// Container instances with a disconnected agent can't run tasks at all, so they go next.
+	if d.Config.PreferDisconnectedAgents {
+		passes = append(passes, discoveryPass{label: "agentConnected == false", find: func() ([]*string, error) {
+			arns, err := d.findDisconnectedContainerInstances(ctx)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("Found %d instances with disconnected agents\n", len(arns))
+			return arns, nil
+		}})
+	}
+
+	// Container instances on spot instances about to be interrupted are going away anyway, so they go next.
+	if d.Config.PreferRebalanceRecommended {
+		passes = append(passes, discoveryPass{label: "spotStatus == marked-for-*", find: func() ([]*string, error) {
+			arns, err := d.findContainerInstancesMarkedForInterruption(ctx)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("Found %d instances marked for spot interruption\n", len(arns))
+			return arns, nil
+		}})
+	}
+
+	// Container instances where tasks keep failing, e.g. crash looping or running out of memory, are next.
+	if d.Config.PreferFailedTaskHosts {
+		lookback := d.Config.FailedTaskLookback
+		if lookback <= 0 {
+			lookback = defaultFailedTaskLookback
+		}
+		passes = append(passes, discoveryPass{label: fmt.Sprintf("failedTasks in last %s", lookback), find: func() ([]*string, error) {
+			return d.findContainerInstancesWithFailedTasks(ctx, time.Now().Add(-lookback))
+		}})
+	}
+
+	// Container instances hosting tasks of a specific task definition are next.
+	if d.Config.TaskDefinitionFilter != "" {
+		passes = append(passes, discoveryPass{label: "taskDefinition == " + d.Config.TaskDefinitionFilter, find: func() ([]*string, error) {
+			return d.findContainerInstancesRunningTaskDefinition(ctx, d.Config.TaskDefinitionFilter)
+		}})
+	}
+
+	// Container instances with old agent are next.
 	if d.Config.AgentVersionThreshold != "" {
-		query := "agentVersion < " + d.Config.AgentVersionThreshold
-		fmt.Printf("Finding instances with %s\n", query)
-		if err := findInstances(query); err != nil {
+		passes = append(passes, listPass("agentVersion < "+d.Config.AgentVersionThreshold))
+	}
+
+	// Container instances launched before a cutoff, e.g. an AMI update, are next.
+	if !d.Config.LaunchedBefore.IsZero() {
+		passes = append(passes, discoveryPass{label: "launchTime < " + d.Config.LaunchedBefore.Format(time.RFC3339), find: func() ([]*string, error) {
+			return d.findContainerInstancesLaunchedBefore(ctx, d.Config.LaunchedBefore)
+		}})
+	}
+
+	// Container instances past the retirement time in their expiry attribute are next.
+	if d.Config.AttributeExpiryKey != "" {
+		passes = append(passes, discoveryPass{label: d.Config.AttributeExpiryKey + " < now", find: func() ([]*string, error) {
+			return d.findExpiredContainerInstances(ctx, d.Config.AttributeExpiryKey, time.Now())
+		}})
+	}
+
+	// Container instances of the matching types are next-pick for draining, in the order given.
+	instanceTypes := d.InstanceTypes
+	if d.InstanceType != "" {
+		instanceTypes = append([]string{d.InstanceType}, instanceTypes...)
+	}
+	for _, instanceType := range instanceTypes {
+		passes = append(passes, listPass("attribute:ecs.instance-type == "+instanceType))
+	}
+
+	// Container instances with little of their memory reserved are next.
+	if d.Config.MaxReservationPercent > 0 {
+		passes = append(passes, discoveryPass{label: fmt.Sprintf("memoryReservation < %g%%", d.Config.MaxReservationPercent), find: func() ([]*string, error) {
+			return d.findUnderReservedContainerInstances(ctx, d.Config.MaxReservationPercent)
+		}})
+	}
+
+	// Instances running few tasks are next.
+	if d.Config.TaskCountDetect {
+		passes = append(passes, discoveryPass{label: "runningTasksCount", find: func() ([]*string, error) {
+			// The number of tasks that can be running on a container instance before it is eligible for draining.
+			runningCount, err := d.drainAtTaskCount(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return d.listContainerInstances(ctx, fmt.Sprintf("runningTasksCount <= %d", runningCount))
+		}})
+	}
+
+	// Anything leftover is last-pick.
+	passes = append(passes, listPass(""))
+
+	for i, pass := range passes {
+		d.explainf("filter %d of %d: %s", i+1, len(passes), selectionReason(pass.label))
+	}
+	found, err := d.runDiscoveryPasses(passes)
+	if err != nil {
+		return nil, err
+	}
+	// Merging in pass order keeps the priority order deterministic however the passes ran.
+	for i, pass := range passes {
+		if err := addInstances(pass.label, found[i]); err != nil {
 			return nil, err
 		}
 	}
 
-	// Container instances of the matching type are next-pick for draining.
-	instanceTypeFilter := ""
-	if d.InstanceType != "" {
-		instanceTypeFilter = "attribute:ecs.instance-type == " + d.InstanceType
-		if err := findInstances(instanceTypeFilter); err != nil {
+	if d.CapacityProviderFilter != "" {
+		before := len(allArns)
+		if allArns, err = d.filterByCapacityProvider(ctx, allArns); err != nil {
 			return nil, err
 		}
+		d.explainf("capacity provider %s kept %d of %d candidates", d.CapacityProviderFilter, len(allArns), before)
+	}
+	if d.SortFunc != nil {
+		if allArns, err = d.sortCandidates(ctx, allArns); err != nil {
+			return nil, err
+		}
+		d.explainf("reordered the %d candidates with SortFunc", len(allArns))
+	} else if d.ScoreWeights != nil {
+		if allArns, err = d.sortCandidatesByScore(ctx, allArns); err != nil {
+			return nil, err
+		}
+		d.explainf("reordered the %d candidates by score", len(allArns))
 	}
 
-	// Instances running few tasks are next.
-	if d.Config.TaskCountDetect {
-		// The number of tasks that can be running on a container instance before it is eligible for draining.
-		runningCount, err := d.drainAtTaskCount(ctx)
+	// If there are c container instances and we want d, drain c - d container instances.
+	drainCount := len(allArns) - int(d.instanceTarget())
+	d.explainf("%d candidates, %d instances desired: draining %d", len(allArns), d.instanceTarget(), drainCount)
+	if drainCount <= 0 {
+		return nil, errors.Wrapf(ErrNoCandidates, "%d container instances are desired, but there are only %d currently running", d.instanceTarget(), len(allArns))
+	}
+
+	// The newest instances still count towards the cluster's size, but can't be drained.
+	protected, err := d.newestContainerInstances(ctx, allArns)
+	if err != nil {
+		return nil, err
+	}
+	// As are instances carrying a tag that forbids draining them.
+	locked, err := d.tagLockedContainerInstances(ctx, allArns)
+	if err != nil {
+		return nil, err
+	}
+	for arn := range locked {
+		protected[arn] = true
+	}
+
+	selectCandidates := func(declined map[string]bool) ([]*string, error) {
+		if d.MinPerAZ > 0 {
+			return d.selectKeepingPerAZ(ctx, allArns, drainCount, protected)
+		}
+		var selected []*string
+		for _, arn := range allArns {
+			if len(selected) == drainCount {
+				break
+			}
+			if !protected[*arn] {
+				selected = append(selected, arn)
+			}
+		}
+		if len(selected) < drainCount {
+			return nil, fmt.Errorf("can only drain %d of %d container instances while protecting %d of them (the %d newest, %d tagged with one of %s, and %d declined)", len(selected), drainCount, len(protected), d.ProtectNewest, len(locked), strings.Join(d.RequireTagAbsent, ", "), len(declined))
+		}
+		return selected, nil
+	}
+	selected, err := selectCandidates(nil)
+	if err != nil {
+		return nil, err
+	}
+	// Instances declined at confirmation are passed over like protected
+	// ones, so others are drained in their place.
+	for d.ConfirmInstance != nil && !d.DryRun && !d.Record {
+		declined, err := d.confirmInstances(ctx, selected)
 		if err != nil {
 			return nil, err
 		}
+		again := false
+		for _, arn := range allArns {
+			if declined[*arn] && !protected[*arn] {
+				protected[*arn] = true
+				again = true
+			}
+		}
+		if !again {
+			break
+		}
+		if selected, err = selectCandidates(declined); err != nil {
+			return nil, err
+		}
+	}
+	if d.CapacityProviderFilter != "" {
+		for _, arn := range selected {
+			fmt.Printf("\t%s selected from capacity provider %s\n", *arn, d.CapacityProviderFilter)
+		}
+	}
+	if d.Explain {
+		for _, arn := range allArns {
+			if key, ok := locked[*arn]; ok {
+				d.explainf("\t%s excluded by its %s tag", *arn, key)
+			} else if d.confirmations[*arn] == ConfirmSkip && protected[*arn] {
+				d.explainf("\t%s declined at confirmation", *arn)
+			} else if protected[*arn] {
+				d.explainf("\t%s protected as one of the %d newest", *arn, d.ProtectNewest)
+			}
+		}
+		d.explainf("final order:")
+		for i, arn := range selected {
+			d.explainf("\t%d. %s: %s", i+1, *arn, d.selectionReasons[*arn])
+		}
+	}
+	return selected, nil
+}
 
-		taskCountFilter := fmt.Sprintf("runningTasksCount <= %d", runningCount)
-		if err := findInstances(taskCountFilter); err != nil {
+// Logs a step of selecting instances to drain when Explain is set.
+func (d *DownScaler) explainf(format string, args ...interface{}) {
+	if d.Explain {
+		log.Printf("explain: "+format, args...)
+	}
+}
+
+// Describes why an instance was selected by the discovery pass with the given label.
+func selectionReason(label string) string {
+	if label == "" {
+		return "leftover"
+	}
+	return label
+}
+
+// One priority group of drain candidates.
+type discoveryPass struct {
+	label string
+	find  func() ([]*string, error)
+}
+
+// Runs each pass, concurrently if ParallelDiscovery is set, returning the
+// candidates found by each pass at the same index.
+func (d *DownScaler) runDiscoveryPasses(passes []discoveryPass) ([][]*string, error) {
+	found := make([][]*string, len(passes))
+
+	if !d.ParallelDiscovery {
+		for i, pass := range passes {
+			if pass.label != "" {
+				fmt.Printf("Finding instances with %s\n", pass.label)
+			}
+			arns, err := pass.find()
+			if err != nil {
+				return nil, err
+			}
+			found[i] = arns
+		}
+		return found, nil
+	}
+
+	errs := make([]error, len(passes))
+	var wg sync.WaitGroup
+	for i, pass := range passes {
+		wg.Add(1)
+		go func(i int, pass discoveryPass) {
+			defer wg.Done()
+			if pass.label != "" {
+				fmt.Printf("Finding instances with %s\n", pass.label)
+			}
+			found[i], errs[i] = pass.find()
+		}(i, pass)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
 			return nil, err
 		}
 	}
+	return found, nil
+}
 
-	// Anything leftover is last-pick.
-	if err := findInstances(""); err != nil {
+// Returns the ARNs of all container instances in the cluster matching the given
+// cluster query language filter. An empty filter matches every instance.
+func (d *DownScaler) listContainerInstances(ctx context.Context, filter string) ([]*string, error) {
+	var arns []*string
+	input := &ecs.ListContainerInstancesInput{
+		Cluster: &d.Cluster,
+	}
+	if filter != "" {
+		input.Filter = aws.String(filter)
+	}
+
+	fn := func(page *ecs.ListContainerInstancesOutput, isLastPage bool) bool {
+		arns = append(arns, page.ContainerInstanceArns...)
+		return page.NextToken != nil
+	}
+	if err := d.ecs.ListContainerInstancesPagesWithContext(ctx, input, fn); err != nil {
 		return nil, err
 	}
+	return arns, nil
+}
 
-	// If there are c container instances and we want d, drain c - d container instances.
-	drainCount := len(allArns) - int(d.DesiredCount)
-	if drainCount <= 0 {
-		return nil, fmt.Errorf("%d container instances are desired, but there are only %d currently running", d.DesiredCount, len(allArns))
+// Describes the given container instances, batching requests to stay within the API limit of 100 per call.
+func (d *DownScaler) describeContainerInstances(ctx context.Context, containerArns []*string) ([]*ecs.ContainerInstance, error) {
+	var instances []*ecs.ContainerInstance
+	for _, page := range paginateStringArray(aws.StringValueSlice(containerArns), 100) {
+		info, err := d.ecs.DescribeContainerInstancesWithContext(ctx, &ecs.DescribeContainerInstancesInput{
+			Cluster:            &d.Cluster,
+			ContainerInstances: aws.StringSlice(page),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot describe container instances")
+		}
+		instances = append(instances, info.ContainerInstances...)
+	}
+	return instances, nil
+}
+
+// Returns the ARNs of container instances whose ECS agent is not connected.
+func (d *DownScaler) findDisconnectedContainerInstances(ctx context.Context) ([]*string, error) {
+	arns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
 	}
 
-	return allArns[0:drainCount], nil
+	var disconnected []*string
+	for _, instance := range instances {
+		if !aws.BoolValue(instance.AgentConnected) {
+			disconnected = append(disconnected, instance.ContainerInstanceArn)
+		}
+	}
+	return disconnected, nil
 }
 
-func (d *DownScaler) drainContainerInstances(ctx context.Context, containerInstanceARNs []*string) ([]*ecs.ContainerInstance, error) {
+// Reports why a stopped task failed, or "" if it didn't: it failed to start,
+// or an essential container exited by itself, non-zero or out of memory.
+// Tasks stopped by a user or the service scheduler, e.g. when scaling in or
+// deploying, didn't fail, and neither did containers stopped along with an
+// essential one, which exit 143 on SIGTERM or 137 on SIGKILL.
+func taskFailure(task *ecs.Task) string {
+	switch aws.StringValue(task.StopCode) {
+	case ecs.TaskStopCodeTaskFailedToStart:
+		return aws.StringValue(task.StoppedReason)
+	case ecs.TaskStopCodeEssentialContainerExited:
+	default:
+		return ""
+	}
+	for _, c := range task.Containers {
+		if strings.Contains(aws.StringValue(c.Reason), "OutOfMemory") {
+			return fmt.Sprintf("container %s: %s", aws.StringValue(c.Name), aws.StringValue(c.Reason))
+		}
+	}
+	for _, c := range task.Containers {
+		switch code := aws.Int64Value(c.ExitCode); {
+		case c.ExitCode == nil, code == 0, code == 137, code == 143:
+		default:
+			return fmt.Sprintf("container %s exited with code %d: %s", aws.StringValue(c.Name), code, aws.StringValue(task.StoppedReason))
+		}
+	}
+	return ""
+}
+
+// Returns the ARNs of container instances where a task of any service failed
+// since the given time, most failures first.
+func (d *DownScaler) findContainerInstancesWithFailedTasks(ctx context.Context, since time.Time) ([]*string, error) {
+	var taskArns []*string
+	fn := func(page *ecs.ListTasksOutput, isLastPage bool) bool {
+		taskArns = append(taskArns, page.TaskArns...)
+		return page.NextToken != nil
+	}
+	err := d.ecs.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:       &d.Cluster,
+		DesiredStatus: aws.String(ecs.DesiredStatusStopped),
+	}, fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list stopped tasks")
+	}
+	tasks, err := d.describeTasks(ctx, taskArns)
+	if err != nil {
+		return nil, err
+	}
+
+	var arns []*string
+	failures := make(map[string]int)
+	for _, task := range tasks {
+		containerArn := aws.StringValue(task.ContainerInstanceArn)
+		if containerArn == "" || aws.TimeValue(task.StoppedAt).Before(since) {
+			continue
+		}
+		reason := taskFailure(task)
+		if reason == "" {
+			continue
+		}
+		if failures[containerArn] == 0 {
+			arns = append(arns, task.ContainerInstanceArn)
+		}
+		failures[containerArn]++
+		fmt.Printf("\t%s had task %s fail: %s\n", containerArn, aws.StringValue(task.TaskArn), reason)
+	}
+	sort.SliceStable(arns, func(i, j int) bool {
+		return failures[*arns[i]] > failures[*arns[j]]
+	})
+	return arns, nil
+}
+
+// Returns the ARNs of container instances whose EC2 instance was launched before the given time.
+func (d *DownScaler) findContainerInstancesLaunchedBefore(ctx context.Context, cutoff time.Time) ([]*string, error) {
+	arns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerEC2Instances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	var launchedBefore []*string
+	for _, arn := range arns {
+		instance, ok := instances[*arn]
+		if ok && instance.LaunchTime != nil && instance.LaunchTime.Before(cutoff) {
+			launchedBefore = append(launchedBefore, arn)
+		}
+	}
+	return launchedBefore, nil
+}
+
+// Returns the ARNs of container instances with less than the given percentage
+// of their registered memory reserved.
+func (d *DownScaler) findUnderReservedContainerInstances(ctx context.Context, maxPercent float64) ([]*string, error) {
+	arns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	var under []*string
+	for _, ci := range instances {
+		registered := resourceValue(ci.RegisteredResources, "MEMORY")
+		if registered <= 0 {
+			continue
+		}
+		reserved := 100 * float64(registered-resourceValue(ci.RemainingResources, "MEMORY")) / float64(registered)
+		if reserved < maxPercent {
+			log.Printf("%s has %.1f%% of its memory reserved", aws.StringValue(ci.ContainerInstanceArn), reserved)
+			under = append(under, ci.ContainerInstanceArn)
+		}
+	}
+	return under, nil
+}
+
+// Returns the ARNs of container instances whose attribute of the given name
+// holds a time before now.
+func (d *DownScaler) findExpiredContainerInstances(ctx context.Context, key string, now time.Time) ([]*string, error) {
+	arns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []*string
+	for _, ci := range instances {
+		for _, attribute := range ci.Attributes {
+			if aws.StringValue(attribute.Name) != key {
+				continue
+			}
+			expiry, err := time.Parse(time.RFC3339, aws.StringValue(attribute.Value))
+			if err != nil {
+				d.warnf("%s has an invalid %s: %s", aws.StringValue(ci.ContainerInstanceArn), key, err)
+				break
+			}
+			due := expiry.Before(now)
+			log.Printf("%s expires at %s (due: %t)", aws.StringValue(ci.ContainerInstanceArn), expiry.Format(time.RFC3339), due)
+			if due {
+				expired = append(expired, ci.ContainerInstanceArn)
+			}
+		}
+	}
+	return expired, nil
+}
+
+// Returns the ARNs of container instances running at least one task of the given
+// task definition. The task definition may be a family ("gql"), a family and
+// revision ("gql:42") or a full task definition ARN.
+func (d *DownScaler) findContainerInstancesRunningTaskDefinition(ctx context.Context, taskDefinition string) ([]*string, error) {
+	familyRevision := taskDefinition
+	if i := strings.LastIndex(familyRevision, "/"); i >= 0 {
+		familyRevision = familyRevision[i+1:]
+	}
+	family := strings.SplitN(familyRevision, ":", 2)[0]
+	matchRevision := strings.Contains(familyRevision, ":")
+
+	var taskArns []*string
+	fn := func(page *ecs.ListTasksOutput, isLastPage bool) bool {
+		taskArns = append(taskArns, page.TaskArns...)
+		return page.NextToken != nil
+	}
+	err := d.ecs.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+		Cluster: &d.Cluster,
+		Family:  &family,
+	}, fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list tasks")
+	}
+
+	tasks, err := d.describeTasks(ctx, taskArns)
+	if err != nil {
+		return nil, err
+	}
+
+	var arns []*string
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		taskDefinitionArn := aws.StringValue(task.TaskDefinitionArn)
+		if matchRevision && !strings.HasSuffix(taskDefinitionArn, "/"+familyRevision) {
+			continue
+		}
+		containerArn := aws.StringValue(task.ContainerInstanceArn)
+		if containerArn == "" || seen[containerArn] {
+			continue
+		}
+		seen[containerArn] = true
+		fmt.Printf("\t%s selected for task %s (%s)\n", containerArn, aws.StringValue(task.TaskArn), taskDefinitionArn)
+		arns = append(arns, task.ContainerInstanceArn)
+	}
+	return arns, nil
+}
+
+// Returns the service's running tasks.
+func (d *DownScaler) listServiceTasks(ctx context.Context) ([]*ecs.Task, error) {
+	var taskArns []*string
+	fn := func(page *ecs.ListTasksOutput, isLastPage bool) bool {
+		taskArns = append(taskArns, page.TaskArns...)
+		return page.NextToken != nil
+	}
+	err := d.ecs.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:     &d.Cluster,
+		ServiceName: &d.Service,
+	}, fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list service tasks")
+	}
+	return d.describeTasks(ctx, taskArns)
+}
+
+// Describes the given tasks, batching requests to stay within the API limit of 100 per call.
+func (d *DownScaler) describeTasks(ctx context.Context, taskArns []*string) ([]*ecs.Task, error) {
+	var tasks []*ecs.Task
+	for _, page := range paginateStringArray(aws.StringValueSlice(taskArns), 100) {
+		out, err := d.ecs.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+			Cluster: &d.Cluster,
+			Tasks:   aws.StringSlice(page),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot describe tasks")
+		}
+		tasks = append(tasks, out.Tasks...)
+	}
+	return tasks, nil
+}
+
+// Sets the given container instances to DRAINING, returning those that were
+// drained and those that failed to be, with the reason they failed.
+func (d *DownScaler) drainContainerInstances(ctx context.Context, containerInstanceARNs []*string) ([]*ecs.ContainerInstance, []DrainFailure, error) {
 	draining := "DRAINING"
 	input := &ecs.UpdateContainerInstancesStateInput{
 		Cluster:            &d.Cluster,
@@ -182,60 +806,296 @@ func (d *DownScaler) drainContainerInstances(ctx context.Context, containerInsta
 	}
 	out, err := d.ecs.UpdateContainerInstancesStateWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return out.ContainerInstances, nil
+	var failures []DrainFailure
+	for _, f := range out.Failures {
+		failures = append(failures, DrainFailure{
+			ContainerInstanceArn: aws.StringValue(f.Arn),
+			Reason:               aws.StringValue(f.Reason),
+		})
+	}
+	return out.ContainerInstances, failures, nil
 }
 
-func (d *DownScaler) sortECSContainersByInstanceAge(ctx context.Context, containerArns []*string) ([]*string, error) {
-	containerArnToEc2ID := make(map[string]string)
-	ec2IDToContainerArn := make(map[string]string)
-	var ec2IDs []string
+// Stops any tasks still running on the given container instances with
+// StopReason, followed by the run's Reason, so they show why they stopped
+// rather than dying with the instance when it is terminated.
+func (d *DownScaler) stopRemainingTasks(ctx context.Context, containerInstances []*ecs.ContainerInstance) error {
+	reason := d.StopReason
+	if d.Reason != "" {
+		reason += ": " + d.Reason
+	}
+	// ECS allows reasons of up to 255 characters.
+	reason = truncate(reason, 255)
 
-	// The API is limited to 100 instances, so run it as many times as needed to satisfy
-	for _, containerArns := range paginateStringArray(aws.StringValueSlice(containerArns), 100) {
-		info, err := d.ecs.DescribeContainerInstancesWithContext(ctx, &ecs.DescribeContainerInstancesInput{
-			Cluster:            &d.Cluster,
-			ContainerInstances: aws.StringSlice(containerArns),
+	for _, ci := range containerInstances {
+		var taskArns []*string
+		fn := func(page *ecs.ListTasksOutput, isLastPage bool) bool {
+			taskArns = append(taskArns, page.TaskArns...)
+			return page.NextToken != nil
+		}
+		err := d.ecs.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+			Cluster:           &d.Cluster,
+			ContainerInstance: ci.ContainerInstanceArn,
+		}, fn)
+		if err != nil {
+			return errors.Wrap(err, "cannot list tasks")
+		}
+
+		for _, taskArn := range taskArns {
+			_, err := d.ecs.StopTaskWithContext(ctx, &ecs.StopTaskInput{
+				Cluster: &d.Cluster,
+				Task:    taskArn,
+				Reason:  &reason,
+			})
+			if err != nil {
+				return errors.Wrapf(err, "cannot stop task %s", *taskArn)
+			}
+			fmt.Printf("\t%s\n", *taskArn)
+		}
+	}
+	return nil
+}
+
+// Returns s cut to at most n characters, on a rune boundary so a cut never
+// leaves invalid UTF-8 behind.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// Deregisters the given container instances from the cluster so they don't
+// linger until ECS cleans them up. Instances that are already deregistered
+// are skipped, so this is safe to repeat.
+func (d *DownScaler) deregisterContainerInstances(ctx context.Context, containerInstances []*ecs.ContainerInstance) error {
+	arns := make([]*string, 0, len(containerInstances))
+	for _, ci := range containerInstances {
+		arns = append(arns, ci.ContainerInstanceArn)
+	}
+	instances, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	for _, ci := range instances {
+		if aws.StringValue(ci.Status) == "INACTIVE" {
+			continue
+		}
+		_, err := d.ecs.DeregisterContainerInstanceWithContext(ctx, &ecs.DeregisterContainerInstanceInput{
+			Cluster:           &d.Cluster,
+			ContainerInstance: ci.ContainerInstanceArn,
+			Force:             &force,
 		})
 		if err != nil {
-			return nil, errors.Wrap(err, "cannot describe container instances")
+			return errors.Wrapf(err, "cannot deregister container instance %s", aws.StringValue(ci.ContainerInstanceArn))
 		}
+		fmt.Printf("\t%s\n", aws.StringValue(ci.ContainerInstanceArn))
+	}
+	return nil
+}
 
-		for _, instance := range info.ContainerInstances {
-			ec2ID := aws.StringValue(instance.Ec2InstanceId)
-			containerArn := aws.StringValue(instance.ContainerInstanceArn)
-			containerArnToEc2ID[containerArn] = ec2ID
-			ec2IDToContainerArn[ec2ID] = containerArn
-			ec2IDs = append(ec2IDs, ec2ID)
+// Returns the longest stopTimeout of the containers in the service's task
+// definition, or zero if none declares one.
+func (d *DownScaler) taskStopTimeout(ctx context.Context, service *ecs.Service) (time.Duration, error) {
+	out, err := d.ecs.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: service.TaskDefinition,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot describe task definition")
+	}
+	var longest int64
+	for _, container := range out.TaskDefinition.ContainerDefinitions {
+		if timeout := aws.Int64Value(container.StopTimeout); timeout > longest {
+			longest = timeout
 		}
 	}
+	return time.Duration(longest) * time.Second, nil
+}
 
-	containerArnToInstanceAge := make(map[string]*time.Time)
+// Waits until the service runs all its desired tasks, none pending, and every
+// load balancer target is healthy, so the tasks of the last batch have been
+// placed on the remaining instances.
+func (d *DownScaler) waitForReschedule(ctx context.Context) (*ecs.Service, error) {
+	timeout := d.WaitForRescheduleTimeout
+	if timeout <= 0 {
+		timeout = defaultRescheduleTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		service, err := d.ecsService(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-	fn := func(page *ec2.DescribeInstancesOutput, hasNext bool) bool {
-		for _, res := range page.Reservations {
-			for _, instance := range res.Instances {
-				containerArn := ec2IDToContainerArn[aws.StringValue(instance.InstanceId)]
-				containerArnToInstanceAge[containerArn] = instance.LaunchTime
+		running, pending, desired := aws.Int64Value(service.RunningCount), aws.Int64Value(service.PendingCount), aws.Int64Value(service.DesiredCount)
+		var unsettled []string
+		if running == desired && pending == 0 {
+			if unsettled, err = d.unsettledTargets(ctx, serviceTargetGroups(service)); err != nil {
+				return nil, err
+			}
+			if len(unsettled) == 0 {
+				return service, nil
 			}
 		}
-		return page.NextToken != nil
+		if !time.Now().Before(deadline) {
+			stuck := fmt.Sprintf("%d/%d tasks running, %d pending", running, desired, pending)
+			if len(unsettled) > 0 {
+				stuck += fmt.Sprintf(", targets not yet healthy: %s", strings.Join(unsettled, ", "))
+			}
+			return nil, fmt.Errorf("tasks not rescheduled after %s: %s; raise -wait-for-reschedule-timeout to wait longer", timeout, stuck)
+		}
+		log.Printf("Waiting for tasks to be rescheduled: %d/%d running, %d pending, %d targets not yet healthy...", running, desired, pending, len(unsettled))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(drainPollInterval):
+		}
 	}
+}
 
-	for _, instanceIDs := range paginateStringArray(ec2IDs, 200) {
-		err := d.ec2.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
-			InstanceIds: aws.StringSlice(instanceIDs),
-		}, fn)
+// How long WaitForReschedule waits when WaitForRescheduleTimeout is zero.
+const defaultRescheduleTimeout = 15 * time.Minute
+
+// Polls the given container instances until none of them are running tasks or
+// the timeout expires, returning the instances still running tasks. A zero
+// timeout waits as long as it takes.
+func (d *DownScaler) waitForTasksToDrain(ctx context.Context, containerInstances []*ecs.ContainerInstance, timeout time.Duration) ([]*ecs.ContainerInstance, error) {
+	deadline := time.Now().Add(timeout)
+	arns := make([]*string, 0, len(containerInstances))
+	for _, ci := range containerInstances {
+		arns = append(arns, ci.ContainerInstanceArn)
+	}
+
+	for {
+		instances, err := d.describeContainerInstances(ctx, arns)
+		if err != nil {
+			return nil, err
+		}
+
+		var busy []*ecs.ContainerInstance
+		for _, ci := range instances {
+			if aws.Int64Value(ci.RunningTasksCount) > 0 {
+				busy = append(busy, ci)
+			}
+		}
+		if len(busy) == 0 || (timeout > 0 && !time.Now().Before(deadline)) {
+			return busy, nil
+		}
+		log.Printf("Waiting for %d container instances to finish draining...", len(busy))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// Returns how many of a batch of the given size can start draining without
+// exceeding MaxConcurrentDraining, waiting until at least one can. The run's
+// warm pool stays DRAINING for good, so it isn't counted.
+func (d *DownScaler) drainingRoom(ctx context.Context, batchSize int) (int, error) {
+	warm := make(map[string]bool, len(d.result.WarmPool))
+	for _, id := range d.result.WarmPool {
+		warm[id] = true
+	}
+	for {
+		arns, err := d.listContainerInstances(ctx, "")
+		if err != nil {
+			return 0, err
+		}
+		instances, err := d.describeContainerInstances(ctx, arns)
 		if err != nil {
-			return nil, errors.Wrap(err, "cannot describe instances")
+			return 0, err
+		}
+		count := 0
+		for _, ci := range instances {
+			if aws.StringValue(ci.Status) == "DRAINING" && !warm[aws.StringValue(ci.Ec2InstanceId)] {
+				count++
+			}
+		}
+
+		room := d.MaxConcurrentDraining - count
+		if room >= batchSize {
+			return batchSize, nil
+		}
+		if room > 0 {
+			log.Printf("Limiting batch to %d container instances: %d are already draining, at most %d may be", room, count, d.MaxConcurrentDraining)
+			return room, nil
+		}
+		if d.Record {
+			// Nothing really drained, so nothing will finish draining.
+			return batchSize, nil
+		}
+		log.Printf("Waiting for some of the %d draining container instances to finish before draining more...", count)
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(drainPollInterval):
 		}
 	}
+}
+
+// Waits until at least MinHealthyAfter container instances are ACTIVE with
+// their agent connected, recording how many are on the result.
+func (d *DownScaler) waitForHealthyInstances(ctx context.Context) error {
+	if d.MinHealthyAfter <= 0 || d.Record {
+		return nil
+	}
+	deadline := time.Now().Add(d.MinHealthyAfterTimeout)
+
+	for {
+		arns, err := d.listContainerInstances(ctx, "")
+		if err != nil {
+			return err
+		}
+		instances, err := d.describeContainerInstances(ctx, arns)
+		if err != nil {
+			return err
+		}
+		var healthy int64
+		for _, ci := range instances {
+			if aws.BoolValue(ci.AgentConnected) && aws.StringValue(ci.Status) == "ACTIVE" {
+				healthy++
+			}
+		}
+		d.result.HealthyInstances = healthy
+		if healthy >= d.MinHealthyAfter {
+			log.Printf("%d container instances are healthy, at least %d needed", healthy, d.MinHealthyAfter)
+			return nil
+		}
+		if d.MinHealthyAfterTimeout > 0 && !time.Now().Before(deadline) {
+			return fmt.Errorf("only %d container instances are ACTIVE and connected after %s, fewer than the %d needed", healthy, d.MinHealthyAfterTimeout, d.MinHealthyAfter)
+		}
+		log.Printf("Waiting for %d healthy container instances, %d so far...", d.MinHealthyAfter, healthy)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+func (d *DownScaler) sortECSContainersByInstanceAge(ctx context.Context, containerArns []*string) ([]*string, error) {
+	instances, err := d.describeContainerEC2Instances(ctx, containerArns)
+	if err != nil {
+		return nil, err
+	}
+	containerArnToInstanceAge := make(map[string]*time.Time)
+	for containerArn, instance := range instances {
+		containerArnToInstanceAge[containerArn] = instance.LaunchTime
+	}
 
 	work := aws.StringValueSlice(containerArns)
 
-	var err error
 	sort.Slice(work, func(i, j int) bool {
 		ti := containerArnToInstanceAge[work[i]]
 		tj := containerArnToInstanceAge[work[j]]
@@ -249,6 +1109,9 @@ func (d *DownScaler) sortECSContainersByInstanceAge(ctx context.Context, contain
 			log.Print(err)
 			return false
 		}
+		if d.LIFO {
+			return ti.After(*tj)
+		}
 		return tj.After(*ti)
 	})
 	return aws.StringSlice(work), err
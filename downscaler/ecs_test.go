@@ -0,0 +1,219 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func TestFlipRestoreForceNewDeployment(t *testing.T) {
+	for _, force := range []bool{false, true} {
+		cluster := newFakeCluster(4)
+		config := cluster.config()
+		config.InstanceFlip = true
+		config.DesiredCount = 2
+		config.BatchSize = 2
+		config.ForceNewDeployment = force
+		d, fake := newTestDownScaler(t, config, cluster.handlers())
+
+		if _, err := d.Run(); err != nil {
+			t.Fatal(err)
+		}
+		updates := fake.inputs("UpdateService")
+		if len(updates) != 2 {
+			t.Fatalf("service updated %d times; want 2", len(updates))
+		}
+		scale, restore := updates[0].(*ecs.UpdateServiceInput), updates[1].(*ecs.UpdateServiceInput)
+		if *scale.ForceNewDeployment {
+			t.Errorf("ForceNewDeployment %t: scaling down forced a new deployment", force)
+		}
+		if *restore.DesiredCount != 4 || *restore.ForceNewDeployment != force {
+			t.Errorf("ForceNewDeployment %t: restored to %d tasks with ForceNewDeployment %t; want 4 with %t", force, *restore.DesiredCount, *restore.ForceNewDeployment, force)
+		}
+	}
+}
+
+func TestWaitForRescheduleTimeout(t *testing.T) {
+	cluster := newFakeCluster(2)
+	config := cluster.config()
+	config.WaitForRescheduleTimeout = time.Nanosecond
+	handlers := cluster.handlers()
+	handlers["DescribeServices"] = func(interface{}) (interface{}, error) {
+		service := cluster.service()
+		service.LoadBalancers = []*ecs.LoadBalancer{{TargetGroupArn: aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/service/1")}}
+		return &ecs.DescribeServicesOutput{Services: []*ecs.Service{service}}, nil
+	}
+	handlers["DescribeTargetHealth"] = func(interface{}) (interface{}, error) {
+		return &elbv2.DescribeTargetHealthOutput{TargetHealthDescriptions: []*elbv2.TargetHealthDescription{
+			{Target: &elbv2.TargetDescription{Id: aws.String("i-01"), Port: aws.Int64(8080)}, TargetHealth: &elbv2.TargetHealth{State: aws.String(elbv2.TargetHealthStateEnumHealthy)}},
+			{Target: &elbv2.TargetDescription{Id: aws.String("i-02"), Port: aws.Int64(8080)}, TargetHealth: &elbv2.TargetHealth{State: aws.String(elbv2.TargetHealthStateEnumUnhealthy)}},
+		}}, nil
+	}
+	d, _ := newTestDownScaler(t, config, handlers)
+
+	_, err := d.waitForReschedule(context.Background())
+	want := "tasks not rescheduled after 1ns: 2/2 tasks running, 0 pending, targets not yet healthy: i-02:8080 (unhealthy)"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got error %v; want %q", err, want)
+	}
+}
+
+func TestTaskFailure(t *testing.T) {
+	container := func(name string, exitCode int64, reason string) *ecs.Container {
+		return &ecs.Container{Name: aws.String(name), ExitCode: aws.Int64(exitCode), Reason: aws.String(reason)}
+	}
+	tests := []struct {
+		name       string
+		stopCode   string
+		containers []*ecs.Container
+		want       string
+	}{
+		{
+			name:     "failed to start",
+			stopCode: ecs.TaskStopCodeTaskFailedToStart,
+			want:     "stopped",
+		},
+		{
+			name:       "essential container exited non-zero",
+			stopCode:   ecs.TaskStopCodeEssentialContainerExited,
+			containers: []*ecs.Container{container("app", 1, ""), container("sidecar", 143, "")},
+			want:       "container app exited with code 1: stopped",
+		},
+		{
+			name:       "essential container out of memory",
+			stopCode:   ecs.TaskStopCodeEssentialContainerExited,
+			containers: []*ecs.Container{container("sidecar", 143, ""), container("app", 137, "OutOfMemoryError: Container killed due to memory usage")},
+			want:       "container app: OutOfMemoryError: Container killed due to memory usage",
+		},
+		{
+			name:       "essential container exited zero",
+			stopCode:   ecs.TaskStopCodeEssentialContainerExited,
+			containers: []*ecs.Container{container("app", 0, ""), container("sidecar", 137, "")},
+		},
+		{
+			name:       "stopped by the scheduler",
+			stopCode:   "ServiceSchedulerInitiated",
+			containers: []*ecs.Container{container("app", 143, "")},
+		},
+		{
+			name:       "stopped by a user",
+			stopCode:   ecs.TaskStopCodeUserInitiated,
+			containers: []*ecs.Container{container("app", 137, "")},
+		},
+	}
+	for _, test := range tests {
+		task := &ecs.Task{StopCode: aws.String(test.stopCode), StoppedReason: aws.String("stopped"), Containers: test.containers}
+		if got := taskFailure(task); got != test.want {
+			t.Errorf("%s: got %q; want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDrainingRoomWarmPool(t *testing.T) {
+	cluster := newFakeCluster(4)
+	cluster.drained = []string{"i-03", "i-04"}
+	config := cluster.config()
+	config.MaxConcurrentDraining = 2
+	d, _ := newTestDownScaler(t, config, cluster.handlers())
+	d.result.WarmPool = []string{"i-04"}
+	// Waiting for room would mean the warm pool was counted.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	room, err := d.drainingRoom(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room != 1 {
+		t.Errorf("room for %d container instances; want 1", room)
+	}
+}
+
+// Discovery passes run in parallel may all warn; run with -race.
+func TestParallelDiscoveryWarnings(t *testing.T) {
+	cluster := newFakeCluster(4)
+	handlers := cluster.handlers()
+	describe := handlers["DescribeContainerInstances"]
+	handlers["DescribeContainerInstances"] = func(input interface{}) (interface{}, error) {
+		out, err := describe(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, ci := range out.(*ecs.DescribeContainerInstancesOutput).ContainerInstances {
+			ci.Attributes = []*ecs.Attribute{{Name: aws.String("retire-after"), Value: aws.String("soon")}}
+		}
+		return out, nil
+	}
+	config := cluster.config()
+	config.ParallelDiscovery = true
+	// The service has no target groups, so this pass warns too.
+	config.PreferUnhealthy = true
+	config.AttributeExpiryKey = "retire-after"
+	d, _ := newTestDownScaler(t, config, handlers)
+
+	if _, err := d.findDrainableContainerInstances(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// One for the target groups, and one per instance for its expiry.
+	if len(d.result.Warnings) != 5 {
+		t.Errorf("got %d warnings %q; want 5", len(d.result.Warnings), d.result.Warnings)
+	}
+}
+
+func TestRunDiscoveryPassesInParallel(t *testing.T) {
+	d, _ := newTestDownScaler(t, &Config{ParallelDiscovery: true}, nil)
+	const n, warnings = 8, 10
+	var started sync.WaitGroup
+	started.Add(n)
+	var passes []discoveryPass
+	for i := 0; i < n; i++ {
+		i := i
+		passes = append(passes, discoveryPass{find: func() ([]*string, error) {
+			// Have every pass warn at once.
+			started.Done()
+			started.Wait()
+			for j := 0; j < warnings; j++ {
+				d.warnf("pass %d, warning %d", i, j)
+			}
+			return fakeContainerArns(fmt.Sprintf("i-%02d", i+1)), nil
+		}})
+	}
+
+	found, err := d.runDiscoveryPasses(passes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, arns := range found {
+		if want := fakeContainerArn(fmt.Sprintf("i-%02d", i+1)); len(arns) != 1 || *arns[0] != want {
+			t.Errorf("pass %d found %q; want %q", i, aws.StringValueSlice(arns), want)
+		}
+	}
+	if len(d.result.Warnings) != n*warnings {
+		t.Errorf("got %d warnings; want %d", len(d.result.Warnings), n*warnings)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"cost savings", 255, "cost savings"},
+		{"cost savings", 4, "cost"},
+		{"économies", 2, "éc"},
+		{"コスト削減", 3, "コスト"},
+	}
+	for _, test := range tests {
+		if got := truncate(test.s, test.n); got != test.want {
+			t.Errorf("truncate(%q, %d) = %q; want %q", test.s, test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/pkg/errors"
+)
+
+func serviceTargetGroups(service *ecs.Service) []string {
+	var targetGroups []string
+	for _, lb := range service.LoadBalancers {
+		if arn := aws.StringValue(lb.TargetGroupArn); arn != "" {
+			targetGroups = append(targetGroups, arn)
+		}
+	}
+	return targetGroups
+}
+
+// Returns the targets of the target groups that are neither healthy nor
+// draining, i.e. still starting up or failing health checks, as
+// "id:port (state)".
+func (d *DownScaler) unsettledTargets(ctx context.Context, targetGroups []string) ([]string, error) {
+	var unsettled []string
+	for _, targetGroup := range targetGroups {
+		out, err := d.elbv2.DescribeTargetHealthWithContext(ctx, &elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(targetGroup),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot describe target health of %s", targetGroup)
+		}
+		for _, target := range out.TargetHealthDescriptions {
+			switch state := aws.StringValue(target.TargetHealth.State); state {
+			case elbv2.TargetHealthStateEnumHealthy, elbv2.TargetHealthStateEnumDraining:
+			default:
+				unsettled = append(unsettled, fmt.Sprintf("%s:%d (%s)", aws.StringValue(target.Target.Id), aws.Int64Value(target.Target.Port), state))
+			}
+		}
+	}
+	return unsettled, nil
+}
+
+// Finds the container instances hosting the service's load balancer targets
+// that are failing health checks. Targets are matched by instance ID, or by
+// the task's private IP for awsvpc tasks.
+func (d *DownScaler) findContainerInstancesHostingUnhealthyTargets(ctx context.Context) ([]*string, error) {
+	service, err := d.ecsService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	targetGroups := serviceTargetGroups(service)
+	if len(targetGroups) == 0 {
+		d.warnf("service %s has no target groups, so unhealthy targets can't be preferred", d.Service)
+		return nil, nil
+	}
+
+	tasks, err := d.listServiceTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var containerArns []*string
+	hosts := make(map[string]string)
+	for _, task := range tasks {
+		containerArn := aws.StringValue(task.ContainerInstanceArn)
+		if containerArn == "" {
+			continue
+		}
+		if _, ok := hosts[containerArn]; !ok {
+			containerArns = append(containerArns, task.ContainerInstanceArn)
+		}
+		hosts[containerArn] = containerArn
+		for _, attachment := range task.Attachments {
+			for _, detail := range attachment.Details {
+				if aws.StringValue(detail.Name) == "privateIPv4Address" {
+					hosts[aws.StringValue(detail.Value)] = containerArn
+				}
+			}
+		}
+	}
+	if len(containerArns) == 0 {
+		return nil, nil
+	}
+	containerInstances, err := d.describeContainerInstances(ctx, containerArns)
+	if err != nil {
+		return nil, err
+	}
+	for _, ci := range containerInstances {
+		hosts[aws.StringValue(ci.Ec2InstanceId)] = aws.StringValue(ci.ContainerInstanceArn)
+	}
+
+	var arns []*string
+	seen := make(map[string]bool)
+	for _, targetGroup := range targetGroups {
+		out, err := d.elbv2.DescribeTargetHealthWithContext(ctx, &elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(targetGroup),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot describe target health of %s", targetGroup)
+		}
+		for _, target := range out.TargetHealthDescriptions {
+			state := aws.StringValue(target.TargetHealth.State)
+			if state != elbv2.TargetHealthStateEnumUnhealthy {
+				continue
+			}
+			containerArn, ok := hosts[aws.StringValue(target.Target.Id)]
+			if !ok || seen[containerArn] {
+				continue
+			}
+			seen[containerArn] = true
+			fmt.Printf("\t%s selected for target %s:%d in %s: %s (%s)\n", containerArn, aws.StringValue(target.Target.Id), aws.Int64Value(target.Target.Port),
+				targetGroup, state, aws.StringValue(target.TargetHealth.Description))
+			arns = append(arns, aws.String(containerArn))
+		}
+	}
+	return arns, nil
+}
@@ -0,0 +1,54 @@
+package downscaler
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Rough durations of each phase of a batch, used to estimate how long a run
+// will take. They are guesses, not measurements.
+const (
+	defaultStableWait      = 5 * time.Minute
+	estimatedASGWait       = 1 * time.Minute
+	estimatedTerminateWait = 3 * time.Minute
+)
+
+// Estimates how long the service takes to become stable after an update from
+// how long its recent deployments took to roll out.
+func estimateStableWait(service *ecs.Service) time.Duration {
+	var total time.Duration
+	n := 0
+	for _, deployment := range service.Deployments {
+		if deployment.CreatedAt == nil || deployment.UpdatedAt == nil {
+			continue
+		}
+		if took := aws.TimeValue(deployment.UpdatedAt).Sub(aws.TimeValue(deployment.CreatedAt)); took > 0 {
+			total += took
+			n++
+		}
+	}
+	if n == 0 {
+		return defaultStableWait
+	}
+	return total / time.Duration(n)
+}
+
+// Estimates how long a run of the given number of batches will take.
+func (d *DownScaler) estimateRunDuration(service *ecs.Service, batches int) time.Duration {
+	stableWait := estimateStableWait(service)
+
+	perBatch := stableWait + d.DrainGracePeriod + estimatedTerminateWait
+	if !d.InstanceFlip {
+		perBatch += estimatedASGWait
+	}
+
+	// Restoring the task count in flip mode, or the final ASG resize otherwise.
+	final := estimatedASGWait
+	if d.InstanceFlip {
+		final = stableWait
+	}
+
+	return time.Duration(batches)*perBatch + final
+}
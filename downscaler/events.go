@@ -0,0 +1,70 @@
+package downscaler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+)
+
+// The source and detail type of the events put on EventBridgeBus.
+const (
+	eventSource     = "ecs-down"
+	eventDetailType = "ECS Scale-Down Progress"
+)
+
+// The detail of the events put on EventBridgeBus.
+type eventDetail struct {
+	Cluster      string `json:"cluster"`
+	Service      string `json:"service"`
+	ASG          string `json:"asg"`
+	Phase        Phase  `json:"phase"`
+	Batch        int    `json:"batch"`
+	TotalBatches int    `json:"total_batches"`
+	Drained      int    `json:"drained"`
+	Terminated   int    `json:"terminated"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// Puts an event describing the run's progress on EventBridgeBus. Failing to
+// is logged, but doesn't fail the run.
+func (d *DownScaler) putProgressEvent(ctx context.Context) {
+	if d.EventBridgeBus == "" {
+		return
+	}
+	detail, err := json.Marshal(eventDetail{
+		Cluster:      d.Cluster,
+		Service:      d.Service,
+		ASG:          d.ASG,
+		Phase:        d.progress.Phase,
+		Batch:        d.progress.Batch,
+		TotalBatches: d.progress.TotalBatches,
+		Drained:      d.progress.Drained,
+		Terminated:   d.progress.Terminated,
+		Reason:       d.Reason,
+	})
+	if err != nil {
+		log.Printf("Cannot encode event: %s", err)
+		return
+	}
+
+	out, err := d.events.PutEventsWithContext(ctx, &eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(d.EventBridgeBus),
+			Source:       aws.String(eventSource),
+			DetailType:   aws.String(eventDetailType),
+			Detail:       aws.String(string(detail)),
+		}},
+	})
+	if err != nil {
+		log.Printf("Cannot put event on %s: %s", d.EventBridgeBus, err)
+		return
+	}
+	for _, entry := range out.Entries {
+		if entry.ErrorCode != nil {
+			log.Printf("Cannot put event on %s: %s: %s", d.EventBridgeBus, aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage))
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package downscaler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Answers a request's input with its output, or an error.
+type fakeHandler func(input interface{}) (interface{}, error)
+
+// A fake AWS API: requests go to the handler for their operation name
+// rather than over the network, and are recorded unless their context is
+// done.
+type fakeAWS struct {
+	t        *testing.T
+	handlers map[string]fakeHandler
+
+	mu    sync.Mutex
+	calls map[string][]interface{}
+}
+
+// Returns a DownScaler for config whose AWS requests are answered by
+// handlers. A request without a handler fails the test. Requests aren't
+// retried unless config says so.
+func newTestDownScaler(t *testing.T, config *Config, handlers map[string]fakeHandler) (*DownScaler, *fakeAWS) {
+	config.Region = "us-east-1"
+	if config.AWSMaxRetries == nil {
+		config.AWSMaxRetries = aws.Int(0)
+	}
+	d := New(config)
+	f := &fakeAWS{t: t, handlers: handlers, calls: make(map[string][]interface{})}
+	clients := []*client.Client{
+		d.aas.Client, d.asg.Client, d.ec2.Client, d.ecs.Client, d.elbv2.Client, d.events.Client,
+		d.sqs.Client, d.dynamodb.Client, d.sts.Client, d.iam.Client, d.logs.Client, d.s3.Client,
+	}
+	for _, c := range clients {
+		c.Handlers.Sign.Clear()
+		c.Handlers.Send.Clear()
+		c.Handlers.Send.PushBack(f.send)
+		c.Handlers.ValidateResponse.Clear()
+		c.Handlers.UnmarshalMeta.Clear()
+		c.Handlers.Unmarshal.Clear()
+		c.Handlers.UnmarshalError.Clear()
+	}
+	return d, f
+}
+
+func (f *fakeAWS) send(r *request.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := r.Operation.Name
+	r.HTTPResponse = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	if err := r.Context().Err(); err != nil {
+		// As the SDK's HTTP client would fail it.
+		r.Error = awserr.New(request.CanceledErrorCode, "request context canceled", err)
+		return
+	}
+	f.calls[name] = append(f.calls[name], r.Params)
+	handler, ok := f.handlers[name]
+	if !ok {
+		f.t.Errorf("unexpected %s request", name)
+		r.Error = awserr.New("UnexpectedRequest", name, nil)
+		return
+	}
+	out, err := handler(r.Params)
+	if err != nil {
+		r.Error = err
+		return
+	}
+	reflect.ValueOf(r.Data).Elem().Set(reflect.ValueOf(out).Elem())
+}
+
+// Returns the inputs of the requests made for the operation.
+func (f *fakeAWS) inputs(operation string) []interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[operation]
+}
+
+// A fake cluster running one service on one ASG, which drains, scales and
+// terminates the way ECS and the ASG would, one task per instance.
+type fakeCluster struct {
+	desiredCount int64
+	asgDesired   int64
+	asgMin       int64
+	asgMax       int64
+
+	// EC2 IDs of the instances in the ASG, in launch order.
+	instances []string
+	// EC2 ID -> why ECS fails to drain it.
+	drainFailures map[string]string
+	// EC2 IDs of the instances drained.
+	drained []string
+	// Desired capacities the ASG was set to below its undrained instances,
+	// which would have it terminate instances still running tasks.
+	undrainedCuts []int64
+}
+
+// Returns a cluster of n instances, all in the ASG, running n tasks.
+func newFakeCluster(n int) *fakeCluster {
+	c := &fakeCluster{
+		desiredCount:  int64(n),
+		asgDesired:    int64(n),
+		asgMin:        int64(n),
+		asgMax:        int64(n),
+		drainFailures: make(map[string]string),
+	}
+	for i := 0; i < n; i++ {
+		c.instances = append(c.instances, fmt.Sprintf("i-%02d", i+1))
+	}
+	return c
+}
+
+const (
+	fakeClusterName = "cluster"
+	fakeServiceName = "service"
+	fakeASGName     = "asg"
+)
+
+// Returns the ARN of the container instance on the EC2 instance.
+func fakeContainerArn(ec2ID string) string {
+	return "arn:aws:ecs:us-east-1:123456789012:container-instance/" + fakeClusterName + "/" + ec2ID
+}
+
+// Returns the EC2 ID of the instance the container instance runs on.
+func fakeEC2ID(containerArn string) string {
+	return containerArn[strings.LastIndex(containerArn, "/")+1:]
+}
+
+// Returns the container instance ARNs of the instances.
+func fakeContainerArns(ec2IDs ...string) []*string {
+	var arns []*string
+	for _, id := range ec2IDs {
+		arns = append(arns, aws.String(fakeContainerArn(id)))
+	}
+	return arns
+}
+
+// Returns a Config for the cluster's service and ASG that waits for nothing.
+func (c *fakeCluster) config() *Config {
+	return &Config{
+		Cluster:           fakeClusterName,
+		Service:           fakeServiceName,
+		ASG:               fakeASGName,
+		BatchSize:         1,
+		SkipStableWait:    true,
+		SkipCapacityCheck: true,
+	}
+}
+
+func (c *fakeCluster) service() *ecs.Service {
+	return &ecs.Service{
+		ServiceName:    aws.String(fakeServiceName),
+		ServiceArn:     aws.String("arn:aws:ecs:us-east-1:123456789012:service/" + fakeClusterName + "/" + fakeServiceName),
+		Status:         aws.String("ACTIVE"),
+		DesiredCount:   aws.Int64(c.desiredCount),
+		RunningCount:   aws.Int64(c.desiredCount),
+		PendingCount:   aws.Int64(0),
+		LaunchType:     aws.String(ecs.LaunchTypeEc2),
+		TaskDefinition: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/" + fakeServiceName + ":1"),
+	}
+}
+
+func (c *fakeCluster) group() *autoscaling.Group {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String(fakeASGName),
+		DesiredCapacity:      aws.Int64(c.asgDesired),
+		MinSize:              aws.Int64(c.asgMin),
+		MaxSize:              aws.Int64(c.asgMax),
+	}
+	for _, id := range c.instances {
+		group.Instances = append(group.Instances, &autoscaling.Instance{
+			InstanceId:     aws.String(id),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+		})
+	}
+	return group
+}
+
+func (c *fakeCluster) isDrained(ec2ID string) bool {
+	for _, id := range c.drained {
+		if id == ec2ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *fakeCluster) containerInstance(ec2ID string) *ecs.ContainerInstance {
+	status := "ACTIVE"
+	if c.isDrained(ec2ID) {
+		status = "DRAINING"
+	}
+	return &ecs.ContainerInstance{
+		ContainerInstanceArn: aws.String(fakeContainerArn(ec2ID)),
+		Ec2InstanceId:        aws.String(ec2ID),
+		Status:               aws.String(status),
+		AgentConnected:       aws.Bool(true),
+		RunningTasksCount:    aws.Int64(0),
+		PendingTasksCount:    aws.Int64(0),
+	}
+}
+
+// Returns the handlers answering for the cluster.
+func (c *fakeCluster) handlers() map[string]fakeHandler {
+	return map[string]fakeHandler{
+		"DescribeAutoScalingGroups": func(interface{}) (interface{}, error) {
+			return &autoscaling.DescribeAutoScalingGroupsOutput{
+				AutoScalingGroups: []*autoscaling.Group{c.group()},
+			}, nil
+		},
+		"DescribeScalingActivities": func(interface{}) (interface{}, error) {
+			return &autoscaling.DescribeScalingActivitiesOutput{}, nil
+		},
+		"UpdateAutoScalingGroup": func(input interface{}) (interface{}, error) {
+			in := input.(*autoscaling.UpdateAutoScalingGroupInput)
+			if in.MinSize != nil {
+				c.asgMin = *in.MinSize
+			}
+			if in.MaxSize != nil {
+				c.asgMax = *in.MaxSize
+			}
+			if in.DesiredCapacity != nil {
+				c.asgDesired = *in.DesiredCapacity
+				undrained := 0
+				for _, id := range c.instances {
+					if !c.isDrained(id) {
+						undrained++
+					}
+				}
+				if c.asgDesired < int64(undrained) {
+					c.undrainedCuts = append(c.undrainedCuts, c.asgDesired)
+				}
+			}
+			return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+		},
+		"TerminateInstanceInAutoScalingGroup": func(input interface{}) (interface{}, error) {
+			in := input.(*autoscaling.TerminateInstanceInAutoScalingGroupInput)
+			for i, id := range c.instances {
+				if id == *in.InstanceId {
+					c.instances = append(c.instances[:i], c.instances[i+1:]...)
+					if *in.ShouldDecrementDesiredCapacity {
+						c.asgDesired--
+					}
+					return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+				}
+			}
+			return nil, awserr.New("ValidationError", "Instance Id not found - No managed instance found for instance ID "+*in.InstanceId, nil)
+		},
+		"DescribeInstances": func(input interface{}) (interface{}, error) {
+			in := input.(*ec2.DescribeInstancesInput)
+			reservation := &ec2.Reservation{}
+			for _, id := range in.InstanceIds {
+				reservation.Instances = append(reservation.Instances, &ec2.Instance{
+					InstanceId:   id,
+					InstanceType: aws.String("m5.large"),
+					State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				})
+			}
+			return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+		},
+		"DescribeClusters": func(interface{}) (interface{}, error) {
+			return &ecs.DescribeClustersOutput{
+				Clusters: []*ecs.Cluster{{
+					ClusterName: aws.String(fakeClusterName),
+					ClusterArn:  aws.String("arn:aws:ecs:us-east-1:123456789012:cluster/" + fakeClusterName),
+					Status:      aws.String("ACTIVE"),
+				}},
+			}, nil
+		},
+		"ListTagsForResource": func(interface{}) (interface{}, error) {
+			return &ecs.ListTagsForResourceOutput{}, nil
+		},
+		"DescribeServices": func(interface{}) (interface{}, error) {
+			return &ecs.DescribeServicesOutput{Services: []*ecs.Service{c.service()}}, nil
+		},
+		"UpdateService": func(input interface{}) (interface{}, error) {
+			c.desiredCount = *input.(*ecs.UpdateServiceInput).DesiredCount
+			return &ecs.UpdateServiceOutput{Service: c.service()}, nil
+		},
+		"DescribeTaskDefinition": func(interface{}) (interface{}, error) {
+			return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{}}, nil
+		},
+		"ListContainerInstances": func(input interface{}) (interface{}, error) {
+			// Without a status, ECS lists both ACTIVE and DRAINING instances.
+			status := aws.StringValue(input.(*ecs.ListContainerInstancesInput).Status)
+			var arns []*string
+			for _, id := range c.instances {
+				if status == "" || aws.StringValue(c.containerInstance(id).Status) == status {
+					arns = append(arns, aws.String(fakeContainerArn(id)))
+				}
+			}
+			return &ecs.ListContainerInstancesOutput{ContainerInstanceArns: arns}, nil
+		},
+		"DescribeContainerInstances": func(input interface{}) (interface{}, error) {
+			out := &ecs.DescribeContainerInstancesOutput{}
+			for _, arn := range input.(*ecs.DescribeContainerInstancesInput).ContainerInstances {
+				out.ContainerInstances = append(out.ContainerInstances, c.containerInstance(fakeEC2ID(*arn)))
+			}
+			return out, nil
+		},
+		"UpdateContainerInstancesState": func(input interface{}) (interface{}, error) {
+			out := &ecs.UpdateContainerInstancesStateOutput{}
+			for _, arn := range input.(*ecs.UpdateContainerInstancesStateInput).ContainerInstances {
+				id := fakeEC2ID(*arn)
+				if reason, ok := c.drainFailures[id]; ok {
+					out.Failures = append(out.Failures, &ecs.Failure{Arn: arn, Reason: aws.String(reason)})
+					continue
+				}
+				c.drained = append(c.drained, id)
+				out.ContainerInstances = append(out.ContainerInstances, c.containerInstance(id))
+			}
+			return out, nil
+		},
+		"ListTasks": func(interface{}) (interface{}, error) {
+			return &ecs.ListTasksOutput{}, nil
+		},
+		"DescribeScalableTargets": func(interface{}) (interface{}, error) {
+			return &applicationautoscaling.DescribeScalableTargetsOutput{}, nil
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package downscaler
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// FIPS endpoints of the services we use, by service and region. The SDK we
+// build against doesn't know about them, so they're kept here. GovCloud's
+// standard EC2 and Auto Scaling endpoints are FIPS validated already.
+var fipsEndpoints = map[string]map[string]string{
+	ecs.EndpointsID: {
+		"us-east-1":     "ecs-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "ecs-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "ecs-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "ecs-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "ecs-fips.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "ecs-fips.us-gov-west-1.amazonaws.com",
+	},
+	ec2.EndpointsID: {
+		"us-east-1":     "ec2-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "ec2-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "ec2-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "ec2-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "ec2.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "ec2.us-gov-west-1.amazonaws.com",
+	},
+	autoscaling.EndpointsID: {
+		"us-east-1":     "autoscaling-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "autoscaling-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "autoscaling-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "autoscaling-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "autoscaling.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "autoscaling.us-gov-west-1.amazonaws.com",
+	},
+	elbv2.EndpointsID: {
+		"us-east-1":     "elasticloadbalancing-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "elasticloadbalancing-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "elasticloadbalancing-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "elasticloadbalancing-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "elasticloadbalancing.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "elasticloadbalancing.us-gov-west-1.amazonaws.com",
+	},
+	eventbridge.EndpointsID: {
+		"us-east-1":     "events-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "events-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "events-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "events-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "events.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "events.us-gov-west-1.amazonaws.com",
+	},
+	sqs.EndpointsID: {
+		"us-east-1":     "sqs-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "sqs-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "sqs-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "sqs-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "sqs.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "sqs.us-gov-west-1.amazonaws.com",
+	},
+	dynamodb.EndpointsID: {
+		"us-east-1":     "dynamodb-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "dynamodb-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "dynamodb-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "dynamodb-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "dynamodb.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "dynamodb.us-gov-west-1.amazonaws.com",
+	},
+	sts.EndpointsID: {
+		"us-east-1":     "sts-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "sts-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "sts-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "sts-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "sts.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "sts.us-gov-west-1.amazonaws.com",
+	},
+	cloudwatchlogs.EndpointsID: {
+		"us-east-1":     "logs-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "logs-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "logs-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "logs-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "logs.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "logs.us-gov-west-1.amazonaws.com",
+	},
+	s3.EndpointsID: {
+		"us-east-1":     "s3-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "s3-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "s3-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "s3-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "s3-fips.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "s3-fips.us-gov-west-1.amazonaws.com",
+	},
+	// IAM is global, with one endpoint per partition.
+	iam.EndpointsID: {
+		"us-east-1":     "iam-fips.amazonaws.com",
+		"us-east-2":     "iam-fips.amazonaws.com",
+		"us-west-1":     "iam-fips.amazonaws.com",
+		"us-west-2":     "iam-fips.amazonaws.com",
+		"us-gov-east-1": "iam.us-gov.amazonaws.com",
+		"us-gov-west-1": "iam.us-gov.amazonaws.com",
+	},
+	applicationautoscaling.EndpointsID: {
+		"us-east-1":     "application-autoscaling-fips.us-east-1.amazonaws.com",
+		"us-east-2":     "application-autoscaling-fips.us-east-2.amazonaws.com",
+		"us-west-1":     "application-autoscaling-fips.us-west-1.amazonaws.com",
+		"us-west-2":     "application-autoscaling-fips.us-west-2.amazonaws.com",
+		"us-gov-east-1": "application-autoscaling.us-gov-east-1.amazonaws.com",
+		"us-gov-west-1": "application-autoscaling.us-gov-west-1.amazonaws.com",
+	},
+}
+
+// Resolves every service to its FIPS endpoint, and fails for services that
+// don't have one in the region rather than falling back to a standard one.
+func fipsResolver(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	hostname, ok := fipsEndpoints[service][region]
+	if !ok {
+		return endpoints.ResolvedEndpoint{}, fmt.Errorf("%s has no FIPS endpoint in %s", service, region)
+	}
+	resolved, err := endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	if err != nil {
+		return resolved, err
+	}
+	resolved.URL = "https://" + hostname
+	return resolved, nil
+}
+
+// Checks up front that every service the run needs has a FIPS endpoint, so
+// it doesn't fail halfway through.
+func (c *Config) checkFIPSEndpoints() error {
+	if !c.UseFIPSEndpoints {
+		return nil
+	}
+	// Every real run looks up the service's scalable target.
+	services := []string{ecs.EndpointsID, ec2.EndpointsID, autoscaling.EndpointsID, applicationautoscaling.EndpointsID}
+	if c.PreferUnhealthy || c.WaitForReschedule {
+		services = append(services, elbv2.EndpointsID)
+	}
+	if c.EventBridgeBus != "" {
+		services = append(services, eventbridge.EndpointsID)
+	}
+	if c.SQSQueueURL != "" {
+		services = append(services, sqs.EndpointsID)
+	}
+	if c.HistoryTable != "" {
+		services = append(services, dynamodb.EndpointsID, sts.EndpointsID)
+	}
+	if c.CloudWatchLogGroup != "" {
+		services = append(services, cloudwatchlogs.EndpointsID)
+	}
+	if c.ScheduleS3URI != "" {
+		services = append(services, s3.EndpointsID)
+	}
+	if c.PreflightIAMCheck {
+		services = append(services, iam.EndpointsID, sts.EndpointsID)
+	}
+	for _, service := range services {
+		if _, ok := fipsEndpoints[service][c.Region]; !ok {
+			return fmt.Errorf("FIPS endpoints are required, but %s has no FIPS endpoint in %s", service, c.Region)
+		}
+	}
+	return nil
+}
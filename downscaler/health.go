@@ -0,0 +1,66 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long to wait between consecutive failing health checks.
+const healthCheckInterval = 10 * time.Second
+
+// Checks HealthCheckURL until it passes, or until it has failed
+// HealthCheckFailures times in a row.
+func (d *DownScaler) checkHealth(ctx context.Context) error {
+	limit := d.HealthCheckFailures
+	if limit < 1 {
+		limit = 1
+	}
+
+	for failures := 1; ; failures++ {
+		err := d.probeHealth(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Health check %d/%d failed: %s", failures, limit, err)
+		if failures >= limit {
+			return errors.Wrapf(err, "aborting: health check failed %d times in a row", failures)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthCheckInterval):
+		}
+	}
+}
+
+// Issues a single GET to HealthCheckURL. It fails on a non-2xx status or if the
+// response took longer than HealthCheckMaxLatency.
+func (d *DownScaler) probeHealth(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, d.HealthCheckURL, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", d.HealthCheckURL, resp.Status)
+	}
+	if d.HealthCheckMaxLatency > 0 && latency > d.HealthCheckMaxLatency {
+		return fmt.Errorf("%s took %s, more than %s", d.HealthCheckURL, latency.Round(time.Millisecond), d.HealthCheckMaxLatency)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package downscaler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+)
+
+// The item written to HistoryTable for each run.
+type historyItem struct {
+	RunID      string `dynamodbav:"runId"`
+	StartedAt  string `dynamodbav:"startedAt"`
+	FinishedAt string `dynamodbav:"finishedAt"`
+	Cluster    string `dynamodbav:"cluster"`
+	Service    string `dynamodbav:"service"`
+	ASG        string `dynamodbav:"asg"`
+
+	// The ARN of the identity the run's AWS requests were made as.
+	Operator string `dynamodbav:"operator"`
+
+	// The run's Reason, if given.
+	Reason string `dynamodbav:"reason,omitempty"`
+
+	Terminated []string `dynamodbav:"terminated"`
+
+	TaskCountBefore   int64 `dynamodbav:"taskCountBefore"`
+	TaskCountAfter    int64 `dynamodbav:"taskCountAfter"`
+	ASGCapacityBefore int64 `dynamodbav:"asgCapacityBefore"`
+	ASGCapacityAfter  int64 `dynamodbav:"asgCapacityAfter"`
+
+	// "succeeded", "no change" or "failed", with Error saying why.
+	Outcome string `dynamodbav:"outcome"`
+	Error   string `dynamodbav:"error,omitempty"`
+}
+
+// The service's desired count and the ASG's desired capacity at some point of
+// a run, or -1 where they couldn't be described.
+type runSizes struct {
+	taskCount   int64
+	asgCapacity int64
+}
+
+func (d *DownScaler) currentSizes(ctx context.Context) runSizes {
+	sizes := runSizes{-1, -1}
+	if service, err := d.ecsService(ctx); err == nil {
+		sizes.taskCount = aws.Int64Value(service.DesiredCount)
+	}
+	if group, err := d.describeASG(ctx); err == nil {
+		sizes.asgCapacity = aws.Int64Value(group.DesiredCapacity)
+	}
+	return sizes
+}
+
+// Writes an item recording the run to HistoryTable. The put is conditional
+// on the run's ID, so a run is never recorded over another.
+func (d *DownScaler) recordHistory(ctx context.Context, runErr error) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	item := historyItem{
+		RunID:      hex.EncodeToString(id),
+		StartedAt:  d.startedAt.UTC().Format(time.RFC3339),
+		FinishedAt: time.Now().UTC().Format(time.RFC3339),
+		Cluster:    d.Cluster,
+		Service:    d.Service,
+		ASG:        d.ASG,
+		Terminated: d.result.Terminated,
+		Reason:     d.Reason,
+
+		TaskCountBefore:   d.sizesBefore.taskCount,
+		ASGCapacityBefore: d.sizesBefore.asgCapacity,
+		Outcome:           "succeeded",
+	}
+	after := d.currentSizes(ctx)
+	item.TaskCountAfter, item.ASGCapacityAfter = after.taskCount, after.asgCapacity
+	switch {
+	case runErr != nil:
+		item.Outcome = "failed"
+		item.Error = runErr.Error()
+	case d.result.NoChange:
+		item.Outcome = "no change"
+	}
+
+	identity, err := d.sts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return errors.Wrap(err, "cannot get caller identity")
+	}
+	item.Operator = aws.StringValue(identity.Arn)
+
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+	_, err = d.dynamodb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           &d.HistoryTable,
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(runId)"),
+	})
+	return errors.Wrap(err, "cannot put history item")
+}
@@ -0,0 +1,187 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+)
+
+// A set of actions to simulate against one resource.
+type permissionCheck struct {
+	resource string
+	actions  []string
+}
+
+// Returns the actions the run needs with its Config, grouped by the resource
+// they act on. Resources that don't support resource-level permissions, or
+// aren't known before the run, are "*".
+func (d *DownScaler) requiredPermissions(clusterArn, serviceArn, groupArn string) []permissionCheck {
+	cluster, err := arn.Parse(clusterArn)
+	if err != nil {
+		return nil
+	}
+	containerInstances := resourceArnIn(cluster, "ecs", "container-instance/"+d.Cluster+"/*")
+	tasks := resourceArnIn(cluster, "ecs", "task/"+d.Cluster+"/*")
+
+	checks := []permissionCheck{
+		{serviceArn, []string{"ecs:DescribeServices", "ecs:UpdateService"}},
+		{clusterArn, []string{"ecs:DescribeClusters", "ecs:ListContainerInstances", "ecs:ListServices", "ecs:ListTagsForResource"}},
+		{containerInstances, []string{"ecs:DescribeContainerInstances", "ecs:UpdateContainerInstancesState"}},
+		{tasks, []string{"ecs:DescribeTasks"}},
+		{groupArn, []string{"autoscaling:UpdateAutoScalingGroup", "autoscaling:TerminateInstanceInAutoScalingGroup"}},
+		{"*", []string{"ecs:ListTasks", "ecs:DescribeTaskDefinition", "autoscaling:DescribeAutoScalingGroups", "autoscaling:DescribeScalingActivities", "ec2:DescribeInstances"}},
+	}
+	add := func(resource string, actions ...string) {
+		checks = append(checks, permissionCheck{resource, actions})
+	}
+	if d.DeregisterContainerInstances {
+		add(containerInstances, "ecs:DeregisterContainerInstance")
+	}
+	if d.StopReason != "" {
+		add(tasks, "ecs:StopTask")
+	}
+	if d.CapacityProviderFilter != "" {
+		add("*", "ecs:DescribeCapacityProviders")
+	}
+	if len(d.SuspendASGProcesses) > 0 {
+		add(groupArn, "autoscaling:SuspendProcesses", "autoscaling:ResumeProcesses")
+	}
+	if d.suspendsScalableTarget() {
+		add("*", "application-autoscaling:DescribeScalableTargets", "application-autoscaling:RegisterScalableTarget")
+	}
+	if d.TerminateOrphansWithEC2 {
+		add(resourceArnIn(cluster, "ec2", "instance/*"), "ec2:TerminateInstances")
+	}
+	if len(d.TagBeforeTerminate) > 0 {
+		add(resourceArnIn(cluster, "ec2", "instance/*"), "ec2:CreateTags")
+	}
+	if d.PreferRebalanceRecommended {
+		add("*", "ec2:DescribeSpotInstanceRequests")
+	}
+	if d.PreferUnhealthy || d.WaitForReschedule {
+		add("*", "elasticloadbalancing:DescribeTargetHealth")
+	}
+	if d.EventBridgeBus != "" {
+		add(resourceArnIn(cluster, "events", "event-bus/"+d.EventBridgeBus), "events:PutEvents")
+	}
+	if d.SQSQueueURL != "" {
+		add("*", "sqs:GetQueueAttributes")
+	}
+	if d.CloudWatchLogGroup != "" {
+		add(resourceArnIn(cluster, "logs", "log-group:"+d.CloudWatchLogGroup+":*"), "logs:CreateLogStream", "logs:DescribeLogStreams", "logs:PutLogEvents")
+	}
+	if bucket, key, err := parseS3URI(d.ScheduleS3URI); err == nil {
+		// S3 ARNs have no region or account.
+		add(arn.ARN{Partition: cluster.Partition, Service: "s3", Resource: bucket + "/" + key}.String(), "s3:GetObject")
+	}
+	if d.HistoryTable != "" {
+		add(resourceArnIn(cluster, "dynamodb", "table/"+d.HistoryTable), "dynamodb:PutItem")
+	}
+	return checks
+}
+
+// Returns the ARN of a resource of another service in the same partition,
+// region and account as base.
+func resourceArnIn(base arn.ARN, service, resource string) string {
+	base.Service = service
+	base.Resource = resource
+	return base.String()
+}
+
+// Returns the ARN of the IAM principal behind the caller's identity, whose
+// policies can be simulated, or "" for the account's root user. An assumed
+// role's session ARN is resolved to the role's ARN with iam:GetRole, since
+// the session ARN leaves out the role's path, e.g. SSO roles'
+// aws-reserved/sso.amazonaws.com/.
+func (d *DownScaler) principalArn(ctx context.Context, identity *sts.GetCallerIdentityOutput) (string, error) {
+	caller, err := arn.Parse(aws.StringValue(identity.Arn))
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case caller.Service == "iam" && caller.Resource == "root":
+		return "", nil
+	case caller.Service == "iam":
+		return caller.String(), nil
+	case caller.Service == "sts" && strings.HasPrefix(caller.Resource, "assumed-role/"):
+		role := strings.Split(caller.Resource, "/")[1]
+		out, err := d.iam.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: &role})
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot get role %s", role)
+		}
+		return aws.StringValue(out.Role.Arn), nil
+	}
+	return "", fmt.Errorf("cannot simulate the policies of %s, which is neither an IAM user nor a role", caller)
+}
+
+// Simulates the caller's policies for every action the run needs, and fails
+// with the list of those that aren't allowed. Policy conditions other than
+// the ECS cluster aren't known up front, so actions allowed only under other
+// conditions are reported as missing. Callers whose policies can't be
+// simulated, e.g. federated users, are only warned about.
+func (d *DownScaler) checkIAMPermissions(ctx context.Context) error {
+	identity, err := d.sts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return errors.Wrap(err, "cannot get caller identity")
+	}
+	principal, err := d.principalArn(ctx, identity)
+	if err != nil {
+		d.warnf("skipping the IAM permission check: %s", err)
+		return nil
+	}
+	if principal == "" {
+		log.Printf("Running as the account's root user; skipping the IAM permission check")
+		return nil
+	}
+
+	service, err := d.ecsService(ctx)
+	if err != nil {
+		return err
+	}
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return err
+	}
+	checks := d.requiredPermissions(aws.StringValue(service.ClusterArn), aws.StringValue(service.ServiceArn), aws.StringValue(group.AutoScalingGroupARN))
+	if checks == nil {
+		return fmt.Errorf("cannot parse the ARN of cluster %s", d.Cluster)
+	}
+
+	log.Printf("Simulating the IAM policies of %s", principal)
+	var missing []string
+	for _, check := range checks {
+		input := &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: &principal,
+			ActionNames:     aws.StringSlice(check.actions),
+			ResourceArns:    []*string{aws.String(check.resource)},
+			ContextEntries: []*iam.ContextEntry{{
+				ContextKeyName:   aws.String("ecs:cluster"),
+				ContextKeyType:   aws.String(iam.ContextKeyTypeEnumString),
+				ContextKeyValues: []*string{service.ClusterArn},
+			}},
+		}
+		err := d.iam.SimulatePrincipalPolicyPagesWithContext(ctx, input, func(page *iam.SimulatePolicyResponse, lastPage bool) bool {
+			for _, result := range page.EvaluationResults {
+				if aws.StringValue(result.EvalDecision) != iam.PolicyEvaluationDecisionTypeAllowed {
+					missing = append(missing, fmt.Sprintf("%s on %s (%s)", aws.StringValue(result.EvalActionName), check.resource, aws.StringValue(result.EvalDecision)))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return errors.Wrapf(err, "cannot simulate the IAM policies of %s", principal)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing %d permissions:\n\t%s", principal, len(missing), strings.Join(missing, "\n\t"))
+	}
+	return nil
+}
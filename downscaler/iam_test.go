@@ -0,0 +1,50 @@
+package downscaler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestPrincipalArn(t *testing.T) {
+	roles := map[string]string{
+		"deployer":                              "arn:aws:iam::123456789012:role/deployer",
+		"AWSReservedSSO_Admin_0123456789abcdef": "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_Admin_0123456789abcdef",
+	}
+	tests := []struct {
+		caller  string
+		want    string
+		wantErr bool
+	}{
+		{caller: "arn:aws:iam::123456789012:root"},
+		{caller: "arn:aws:iam::123456789012:user/ops/alice", want: "arn:aws:iam::123456789012:user/ops/alice"},
+		{caller: "arn:aws:sts::123456789012:assumed-role/deployer/session", want: roles["deployer"]},
+		{caller: "arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/alice@example.com", want: roles["AWSReservedSSO_Admin_0123456789abcdef"]},
+		{caller: "arn:aws:sts::123456789012:assumed-role/deleted/session", wantErr: true},
+		{caller: "arn:aws:sts::123456789012:federated-user/alice", wantErr: true},
+	}
+
+	d, _ := newTestDownScaler(t, &Config{}, map[string]fakeHandler{
+		"GetRole": func(input interface{}) (interface{}, error) {
+			name := aws.StringValue(input.(*iam.GetRoleInput).RoleName)
+			roleArn, ok := roles[name]
+			if !ok {
+				return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "The role with name "+name+" cannot be found.", nil)
+			}
+			return &iam.GetRoleOutput{Role: &iam.Role{RoleName: aws.String(name), Arn: aws.String(roleArn)}}, nil
+		},
+	})
+	for _, test := range tests {
+		got, err := d.principalArn(context.Background(), &sts.GetCallerIdentityOutput{Arn: aws.String(test.caller)})
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v; want error %t", test.caller, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q; want %q", test.caller, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// Fills in Cluster and Service when they aren't given: the cluster is the one
+// the ASG's instances are registered in, and the service the one replica
+// service running in it.
+func (d *DownScaler) inferClusterAndService(ctx context.Context) error {
+	if d.Cluster == "" {
+		cluster, err := d.inferCluster(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("ASG %s's instances are registered in cluster %s", d.ASG, cluster)
+		d.Cluster = cluster
+	}
+	if d.Service == "" {
+		service, err := d.inferService(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Scaling service %s, the only replica service in cluster %s", service, d.Cluster)
+		d.Service = service
+	}
+	return nil
+}
+
+func (d *DownScaler) inferCluster(ctx context.Context) (string, error) {
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return "", err
+	}
+	var ids []string
+	for _, instance := range group.Instances {
+		ids = append(ids, aws.StringValue(instance.InstanceId))
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("cannot infer the cluster: ASG %s has no instances; use -cluster", d.ASG)
+	}
+
+	var clusters []string
+	fn := func(page *ecs.ListClustersOutput, isLastPage bool) bool {
+		clusters = append(clusters, aws.StringValueSlice(page.ClusterArns)...)
+		return page.NextToken != nil
+	}
+	if err := d.ecs.ListClustersPagesWithContext(ctx, &ecs.ListClustersInput{}, fn); err != nil {
+		return "", errors.Wrap(err, "cannot list clusters")
+	}
+
+	var matches []string
+	for _, cluster := range clusters {
+		registered := false
+		// Keep the filter expression short by asking about a few instances at a time.
+		for _, page := range paginateStringArray(ids, 50) {
+			out, err := d.ecs.ListContainerInstancesWithContext(ctx, &ecs.ListContainerInstancesInput{
+				Cluster: aws.String(cluster),
+				Filter:  aws.String(fmt.Sprintf("ec2InstanceId in ['%s']", strings.Join(page, "', '"))),
+			})
+			if err != nil {
+				return "", errors.Wrapf(err, "cannot list container instances of %s", cluster)
+			}
+			if len(out.ContainerInstanceArns) > 0 {
+				registered = true
+				break
+			}
+		}
+		if registered {
+			matches = append(matches, nameFromArn(cluster))
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("cannot infer the cluster: none of ASG %s's instances are registered in a cluster; use -cluster", d.ASG)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("cannot infer the cluster: ASG %s's instances are registered in %s; pick one with -cluster", d.ASG, strings.Join(matches, ", "))
+}
+
+func (d *DownScaler) inferService(ctx context.Context) (string, error) {
+	var arns []string
+	fn := func(page *ecs.ListServicesOutput, isLastPage bool) bool {
+		arns = append(arns, aws.StringValueSlice(page.ServiceArns)...)
+		return page.NextToken != nil
+	}
+	if err := d.ecs.ListServicesPagesWithContext(ctx, &ecs.ListServicesInput{Cluster: &d.Cluster}, fn); err != nil {
+		return "", errors.Wrap(err, "cannot list services")
+	}
+
+	// Daemon services run one task per instance, so they aren't what's scaled down.
+	var replicas []string
+	for _, page := range paginateStringArray(arns, 10) {
+		out, err := d.ecs.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
+			Cluster:  &d.Cluster,
+			Services: aws.StringSlice(page),
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "cannot describe services")
+		}
+		for _, service := range out.Services {
+			if aws.StringValue(service.SchedulingStrategy) != ecs.SchedulingStrategyDaemon {
+				replicas = append(replicas, aws.StringValue(service.ServiceName))
+			}
+		}
+	}
+
+	switch len(replicas) {
+	case 0:
+		return "", fmt.Errorf("cannot infer the service: cluster %s runs no replica services; use -service", d.Cluster)
+	case 1:
+		return replicas[0], nil
+	}
+	return "", fmt.Errorf("cannot infer the service: cluster %s runs %s; pick one with -service", d.Cluster, strings.Join(replicas, ", "))
+}
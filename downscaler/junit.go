@@ -0,0 +1,76 @@
+package downscaler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Writes a JUnit XML report of the run to JUnitReportPath, with a test case
+// per batch. A failure outside any batch is reported as a test case of its own.
+func (d *DownScaler) writeJUnitReport(result *Result, runErr error) error {
+	suite := junitTestSuite{Name: fmt.Sprintf("ecs-down %s/%s", d.Cluster, d.Service)}
+	for i, duration := range result.BatchDurations {
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("batch %d", i+1),
+			ClassName: suite.Name,
+			Time:      duration.Seconds(),
+		}
+		if runErr != nil && result.FailedBatch == i+1 {
+			testCase.Failure = &junitFailure{Message: runErr.Error(), Text: runErr.Error()}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.Time += duration.Seconds()
+	}
+	if runErr != nil && result.FailedBatch == 0 {
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			Name:      "run",
+			ClassName: suite.Name,
+			Failure:   &junitFailure{Message: runErr.Error(), Text: runErr.Error()},
+		})
+	}
+	suite.Tests = len(suite.TestCases)
+	if runErr != nil {
+		suite.Failures = 1
+	}
+
+	out, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append([]byte(xml.Header), append(out, '\n')...)
+	return ioutil.WriteFile(d.JUnitReportPath, out, 0644)
+}
+
+// Returns a report's path for one cluster or ramp step of a run, e.g.
+// "report-visage-a.xml" for "report.xml".
+func reportPathFor(path, name string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
@@ -0,0 +1,54 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// KillSwitchTag is the ECS cluster tag that freezes scale-downs of the
+// cluster when set to "true", whoever runs them and however.
+const KillSwitchTag = "ecs-down:disabled"
+
+// Refuses to run against a cluster carrying KillSwitchTag, unless
+// OverrideKillSwitch is set. Dry and Record runs change nothing, so they go
+// ahead with a warning.
+func (d *DownScaler) checkKillSwitch(ctx context.Context) error {
+	out, err := d.ecs.DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+		Clusters: []*string{&d.Cluster},
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot describe cluster")
+	}
+	if len(out.Clusters) != 1 {
+		return d.clusterNotFound(ctx, nil)
+	}
+	tags, err := d.ecs.ListTagsForResourceWithContext(ctx, &ecs.ListTagsForResourceInput{
+		ResourceArn: out.Clusters[0].ClusterArn,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot list tags of cluster %s to check for %s", d.Cluster, KillSwitchTag)
+	}
+
+	for _, tag := range tags.Tags {
+		if aws.StringValue(tag.Key) != KillSwitchTag || !strings.EqualFold(aws.StringValue(tag.Value), "true") {
+			continue
+		}
+		log.Printf("Cluster %s has the kill-switch tag %s=%s", d.Cluster, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+		frozen := fmt.Sprintf("scale-downs of cluster %s are frozen by its tag %s=%s", d.Cluster, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+		switch {
+		case d.OverrideKillSwitch:
+			d.warnf("%s, but running anyway because the kill switch is overridden", frozen)
+		case d.DryRun || d.Record:
+			d.warnf("%s; a real run would be refused", frozen)
+		default:
+			return fmt.Errorf("%s; remove the tag, or use -override-kill-switch to run anyway", frozen)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is the cause of NotFoundErrors.
+var ErrNotFound = errors.New("not found")
+
+// NotFoundError is returned when the cluster or service doesn't exist, or the
+// name matches more than one.
+type NotFoundError struct {
+	// "cluster" or "service".
+	Kind string
+	Name string
+
+	// The names of what matched, when more than one did.
+	Matches []string
+
+	// Existing names close to Name, closest first.
+	Suggestions []string
+}
+
+func (e *NotFoundError) Error() string {
+	msg := fmt.Sprintf("%s %q not found", e.Kind, e.Name)
+	if len(e.Matches) > 1 {
+		msg = fmt.Sprintf("%s name %q is ambiguous; it matches %s", e.Kind, e.Name, strings.Join(e.Matches, ", "))
+	}
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf("; did you mean %s?", strings.Join(e.Suggestions, " or "))
+	}
+	return msg
+}
+
+// Cause makes errors.Cause return ErrNotFound.
+func (e *NotFoundError) Cause() error {
+	return ErrNotFound
+}
+
+// Returns a NotFoundError for the cluster, suggesting clusters with similar
+// names if they can be listed.
+func (d *DownScaler) clusterNotFound(ctx context.Context, matches []string) error {
+	var names []string
+	fn := func(page *ecs.ListClustersOutput, isLastPage bool) bool {
+		for _, arn := range page.ClusterArns {
+			names = append(names, nameFromArn(*arn))
+		}
+		return page.NextToken != nil
+	}
+	if err := d.ecs.ListClustersPagesWithContext(ctx, &ecs.ListClustersInput{}, fn); err != nil {
+		log.Printf("Cannot list clusters to suggest names: %s", err)
+		names = nil
+	}
+	return &NotFoundError{Kind: "cluster", Name: d.Cluster, Matches: matches, Suggestions: closeMatches(d.Cluster, names)}
+}
+
+// Returns a NotFoundError for the service, suggesting services in the cluster
+// with similar names if they can be listed.
+func (d *DownScaler) serviceNotFound(ctx context.Context, matches []string) error {
+	var names []string
+	fn := func(page *ecs.ListServicesOutput, isLastPage bool) bool {
+		for _, arn := range page.ServiceArns {
+			names = append(names, nameFromArn(*arn))
+		}
+		return page.NextToken != nil
+	}
+	if err := d.ecs.ListServicesPagesWithContext(ctx, &ecs.ListServicesInput{Cluster: &d.Cluster}, fn); err != nil {
+		log.Printf("Cannot list services to suggest names: %s", err)
+		names = nil
+	}
+	return &NotFoundError{Kind: "service", Name: d.Service, Matches: matches, Suggestions: closeMatches(d.Service, names)}
+}
+
+// Returns the last part of an ECS ARN, e.g. the service name of
+// arn:aws:ecs:us-east-1:123456789012:service/cluster/service.
+func nameFromArn(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// Returns up to 3 of the names that are close to name, closest first: names
+// containing it or contained in it, or a few edits away from it.
+func closeMatches(name string, names []string) []string {
+	distances := make(map[string]int)
+	lower := strings.ToLower(name)
+	for _, candidate := range names {
+		c := strings.ToLower(candidate)
+		distance := editDistance(lower, c)
+		if distance <= 2 || distance <= len(name)/3 || strings.Contains(c, lower) || strings.Contains(lower, c) {
+			distances[candidate] = distance
+		}
+	}
+
+	matches := make([]string, 0, len(distances))
+	for candidate := range distances {
+		matches = append(matches, candidate)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if distances[matches[i]] != distances[matches[j]] {
+			return distances[matches[i]] < distances[matches[j]]
+		}
+		return matches[i] < matches[j]
+	})
+	if len(matches) > 3 {
+		matches = matches[:3]
+	}
+	return matches
+}
+
+// Returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
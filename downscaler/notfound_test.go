@@ -0,0 +1,158 @@
+package downscaler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+func TestServiceNotFound(t *testing.T) {
+	listed := func(interface{}) (interface{}, error) {
+		return &ecs.ListServicesOutput{ServiceArns: []*string{
+			aws.String("arn:aws:ecs:us-east-1:123456789012:service/cluster/graphql"),
+			aws.String("arn:aws:ecs:us-east-1:123456789012:service/cluster/dnsmasq"),
+		}}, nil
+	}
+	tests := []struct {
+		name            string
+		services        []string
+		listServices    fakeHandler
+		wantMatches     []string
+		wantSuggestions []string
+	}{
+		{
+			name:            "no match",
+			listServices:    listed,
+			wantSuggestions: []string{"graphql"},
+		},
+		{
+			name:            "several matches",
+			services:        []string{"graphq", "graphq"},
+			listServices:    listed,
+			wantMatches:     []string{"graphq", "graphq"},
+			wantSuggestions: []string{"graphql"},
+		},
+		{
+			name: "no match, services can't be listed",
+			listServices: func(interface{}) (interface{}, error) {
+				return nil, awserr.New("AccessDeniedException", "not authorized to perform ecs:ListServices", nil)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, _ := newTestDownScaler(t, &Config{Cluster: "cluster", Service: "graphq"}, map[string]fakeHandler{
+				"DescribeServices": func(interface{}) (interface{}, error) {
+					out := &ecs.DescribeServicesOutput{}
+					for _, name := range test.services {
+						out.Services = append(out.Services, &ecs.Service{ServiceName: aws.String(name)})
+					}
+					return out, nil
+				},
+				"ListServices": test.listServices,
+			})
+
+			_, err := d.ecsService(context.Background())
+			nf, ok := err.(*NotFoundError)
+			if !ok {
+				t.Fatalf("got %v; want a NotFoundError", err)
+			}
+			if errors.Cause(err) != ErrNotFound {
+				t.Errorf("cause %v; want ErrNotFound", errors.Cause(err))
+			}
+			if nf.Kind != "service" || nf.Name != "graphq" {
+				t.Errorf("%s %q not found; want service %q", nf.Kind, nf.Name, "graphq")
+			}
+			if !sameStrings(nf.Matches, test.wantMatches) {
+				t.Errorf("matches %q; want %q", nf.Matches, test.wantMatches)
+			}
+			if !sameStrings(nf.Suggestions, test.wantSuggestions) {
+				t.Errorf("suggestions %q; want %q", nf.Suggestions, test.wantSuggestions)
+			}
+		})
+	}
+}
+
+func TestClusterNotFound(t *testing.T) {
+	tests := []struct {
+		name            string
+		clusters        []string
+		wantMatches     []string
+		wantSuggestions []string
+	}{
+		{
+			name:            "no match",
+			wantSuggestions: []string{"production"},
+		},
+		{
+			name:            "several matches",
+			clusters:        []string{"prod", "prod"},
+			wantMatches:     []string{"prod", "prod"},
+			wantSuggestions: []string{"production"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, _ := newTestDownScaler(t, &Config{Cluster: "prod"}, map[string]fakeHandler{
+				"DescribeClusters": func(interface{}) (interface{}, error) {
+					out := &ecs.DescribeClustersOutput{}
+					for _, name := range test.clusters {
+						out.Clusters = append(out.Clusters, &ecs.Cluster{ClusterName: aws.String(name), ActiveServicesCount: aws.Int64(1)})
+					}
+					return out, nil
+				},
+				"ListClusters": func(interface{}) (interface{}, error) {
+					return &ecs.ListClustersOutput{ClusterArns: []*string{
+						aws.String("arn:aws:ecs:us-east-1:123456789012:cluster/production"),
+						aws.String("arn:aws:ecs:us-east-1:123456789012:cluster/staging"),
+					}}, nil
+				},
+			})
+
+			_, err := d.drainAtTaskCount(context.Background())
+			nf, ok := err.(*NotFoundError)
+			if !ok {
+				t.Fatalf("got %v; want a NotFoundError", err)
+			}
+			if nf.Kind != "cluster" || nf.Name != "prod" {
+				t.Errorf("%s %q not found; want cluster %q", nf.Kind, nf.Name, "prod")
+			}
+			if !sameStrings(nf.Matches, test.wantMatches) {
+				t.Errorf("matches %q; want %q", nf.Matches, test.wantMatches)
+			}
+			if !sameStrings(nf.Suggestions, test.wantSuggestions) {
+				t.Errorf("suggestions %q; want %q", nf.Suggestions, test.wantSuggestions)
+			}
+		})
+	}
+}
+
+func TestCloseMatches(t *testing.T) {
+	names := []string{"graphql", "graphql-canary", "dnsmasq", "web"}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"graphq", []string{"graphql", "graphql-canary"}},
+		{"GraphQL", []string{"graphql", "graphql-canary"}},
+		{"dnsmask", []string{"dnsmasq"}},
+		{"worker", nil},
+	}
+	for _, test := range tests {
+		if got := closeMatches(test.name, names); !sameStrings(got, test.want) {
+			t.Errorf("closeMatches(%q) = %q; want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// Reports whether a and b hold the same strings, taking nil as empty.
+func sameStrings(a, b []string) bool {
+	return len(a) == 0 && len(b) == 0 || reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,147 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Checks that draining the given container instances won't remove all of the
+// service's tasks from any value of a dimension the service spreads its tasks
+// over, e.g. leave an availability zone without a single task.
+//
+// Spreading over instanceId is only violated if no task at all would be left,
+// since every drained instance necessarily loses its own tasks.
+func (d *DownScaler) checkSpread(ctx context.Context, service *ecs.Service, draining []*string) error {
+	var fields []string
+	for _, strategy := range service.PlacementStrategy {
+		if aws.StringValue(strategy.Type) == ecs.PlacementStrategyTypeSpread {
+			fields = append(fields, aws.StringValue(strategy.Field))
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	tasks, err := d.listServiceTasks(ctx)
+	if err != nil {
+		return err
+	}
+	instances, err := d.describeTaskHosts(ctx, tasks)
+	if err != nil {
+		return err
+	}
+	isDraining := make(map[string]bool)
+	for _, arn := range draining {
+		isDraining[*arn] = true
+	}
+
+	for _, field := range fields {
+		before := make(map[string]int)
+		after := make(map[string]int)
+		remaining := 0
+		for _, task := range tasks {
+			arn := aws.StringValue(task.ContainerInstanceArn)
+			ci, ok := instances[arn]
+			if !ok {
+				continue
+			}
+			value := spreadValue(ci, field)
+			before[value]++
+			if !isDraining[arn] {
+				after[value]++
+				remaining++
+			}
+		}
+
+		if isInstanceIDField(field) {
+			if len(before) > 0 && remaining == 0 {
+				return fmt.Errorf("draining this batch would stop every task of service %s", d.Service)
+			}
+			continue
+		}
+
+		var lost []string
+		for value := range before {
+			if after[value] == 0 {
+				lost = append(lost, value)
+			}
+		}
+		if len(lost) > 0 {
+			return fmt.Errorf("draining this batch would remove every task of service %s from %s %s, which it spreads tasks over", d.Service, field, strings.Join(lost, ", "))
+		}
+	}
+	return nil
+}
+
+// Describes the container instances hosting the given tasks, keyed by ARN.
+func (d *DownScaler) describeTaskHosts(ctx context.Context, tasks []*ecs.Task) (map[string]*ecs.ContainerInstance, error) {
+	var arns []*string
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		arn := aws.StringValue(task.ContainerInstanceArn)
+		if arn != "" && !seen[arn] {
+			seen[arn] = true
+			arns = append(arns, task.ContainerInstanceArn)
+		}
+	}
+
+	described, err := d.describeContainerInstances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+	instances := make(map[string]*ecs.ContainerInstance, len(described))
+	for _, ci := range described {
+		instances[aws.StringValue(ci.ContainerInstanceArn)] = ci
+	}
+	return instances, nil
+}
+
+func isInstanceIDField(field string) bool {
+	return strings.EqualFold(field, "instanceId") || strings.EqualFold(field, "host")
+}
+
+// Returns the value of a placement strategy field, e.g.
+// "attribute:ecs.availability-zone", for a container instance.
+func spreadValue(ci *ecs.ContainerInstance, field string) string {
+	if isInstanceIDField(field) {
+		return aws.StringValue(ci.Ec2InstanceId)
+	}
+	name := strings.TrimPrefix(field, "attribute:")
+	for _, attr := range ci.Attributes {
+		if aws.StringValue(attr.Name) == name {
+			return aws.StringValue(attr.Value)
+		}
+	}
+	return ""
+}
+
+// Returns how many of the batch's container instances, in order, can drain at
+// once without the service's tasks on them taking it below MinServiceReplicas.
+func (d *DownScaler) replicaSafeBatchSize(ctx context.Context, service *ecs.Service, batch []*string) (int, error) {
+	tasks, err := d.listServiceTasks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	hosted := make(map[string]int64)
+	for _, task := range tasks {
+		hosted[aws.StringValue(task.ContainerInstanceArn)]++
+	}
+
+	remaining := aws.Int64Value(service.DesiredCount)
+	for i, arn := range batch {
+		remaining -= hosted[*arn]
+		if remaining < d.MinServiceReplicas {
+			if i == 0 {
+				return 0, fmt.Errorf("draining %s would leave %s with %d of its minimum %d replicas", *arn, d.Service, remaining, d.MinServiceReplicas)
+			}
+			log.Printf("Limiting batch to %d container instances to keep %d replicas of %s", i, d.MinServiceReplicas, d.Service)
+			return i, nil
+		}
+	}
+	return len(batch), nil
+}
@@ -0,0 +1,305 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// Plan describes the batches a dry run would drain.
+type Plan struct {
+	Cluster string `json:"cluster"`
+	Service string `json:"service"`
+	ASG     string `json:"asg"`
+
+	Batches []PlanBatch `json:"batches"`
+
+	// The service's desired task count now and once the run is done.
+	TaskCount      int64 `json:"task_count"`
+	FinalTaskCount int64 `json:"final_task_count"`
+
+	// Whether the run flips instances, in which case the ASG replaces them
+	// and keeps its size, and the task count is restored at the end.
+	InstanceFlip bool `json:"instance_flip"`
+
+	// The ASG's sizes now, and the capacity it's finally set to, which is
+	// also its final minimum and maximum size.
+	ASGCapacity      int64 `json:"asg_capacity"`
+	ASGMinSize       int64 `json:"asg_min_size"`
+	ASGMaxSize       int64 `json:"asg_max_size"`
+	FinalASGCapacity int64 `json:"final_asg_capacity"`
+
+	// EC2 instance IDs of the drained instances the run would keep in its
+	// warm pool, which the final capacity includes.
+	WarmPool []string `json:"warm_pool,omitempty"`
+
+	// The number of tasks running on the drained instances, which ECS stops
+	// and, unless they belong to the service being shrunk, reschedules.
+	DisruptedTasks int64 `json:"disrupted_tasks"`
+
+	// Set when the instances left after the run look too small to take the
+	// rescheduled tasks, with the reason why.
+	InsufficientCapacity string `json:"insufficient_capacity,omitempty"`
+
+	// A rough estimate of how long the run would take.
+	EstimatedDuration time.Duration `json:"-"`
+}
+
+// PlanBatch is one batch of a Plan.
+type PlanBatch struct {
+	ContainerInstanceArns []string `json:"container_instance_arns"`
+	EC2InstanceIDs        []string `json:"ec2_instance_ids"`
+
+	// The service's desired task count once the batch is drained.
+	TaskCount int64 `json:"task_count"`
+
+	// The tasks running on the batch's instances.
+	Tasks []PlanTask `json:"tasks"`
+}
+
+// PlanTask is a task draining a PlanBatch would disrupt.
+type PlanTask struct {
+	TaskArn              string `json:"task_arn"`
+	TaskDefinitionArn    string `json:"task_definition_arn"`
+	Group                string `json:"group"`
+	ContainerInstanceArn string `json:"container_instance_arn"`
+}
+
+// Works out the batches draining the given container instances would take.
+func (d *DownScaler) buildPlan(ctx context.Context, service *ecs.Service, containerInstances []*string, maxToRemove int64) (*Plan, error) {
+	plan := &Plan{
+		Cluster:   d.Cluster,
+		Service:   d.Service,
+		ASG:       d.ASG,
+		TaskCount: *service.DesiredCount,
+
+		InstanceFlip: d.InstanceFlip,
+	}
+
+	described, err := d.describeContainerInstances(ctx, containerInstances)
+	if err != nil {
+		return nil, err
+	}
+	ec2IDs := make(map[string]string)
+	for _, ci := range described {
+		ec2IDs[aws.StringValue(ci.ContainerInstanceArn)] = aws.StringValue(ci.Ec2InstanceId)
+	}
+
+	tasks, err := d.tasksOnContainerInstances(ctx, containerInstances)
+	if err != nil {
+		return nil, err
+	}
+
+	toDrain := len(containerInstances)
+	taskCount := *service.DesiredCount
+	for start := 0; start < toDrain; start += d.BatchSize {
+		end := start + d.BatchSize
+		if end > toDrain {
+			end = toDrain
+		}
+		if d.StepByTaskDensity {
+			taskCount -= tasksForDensity(countServiceTasks(d.Service, tasks, containerInstances[start:end]), taskCount-d.DesiredCount, end == toDrain)
+		} else {
+			taskCount -= tasksForBatch(maxToRemove, toDrain, start, end)
+		}
+		batch := PlanBatch{
+			ContainerInstanceArns: aws.StringValueSlice(containerInstances[start:end]),
+			TaskCount:             taskCount,
+		}
+		for _, arn := range batch.ContainerInstanceArns {
+			batch.EC2InstanceIDs = append(batch.EC2InstanceIDs, ec2IDs[arn])
+			for _, task := range tasks[arn] {
+				batch.Tasks = append(batch.Tasks, PlanTask{
+					TaskArn:              aws.StringValue(task.TaskArn),
+					TaskDefinitionArn:    aws.StringValue(task.TaskDefinitionArn),
+					Group:                aws.StringValue(task.Group),
+					ContainerInstanceArn: arn,
+				})
+			}
+		}
+		plan.DisruptedTasks += int64(len(batch.Tasks))
+		plan.Batches = append(plan.Batches, batch)
+	}
+	plan.FinalTaskCount = taskCount
+
+	group, err := d.describeASG(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan.ASGCapacity = aws.Int64Value(group.DesiredCapacity)
+	plan.ASGMinSize = aws.Int64Value(group.MinSize)
+	plan.ASGMaxSize = aws.Int64Value(group.MaxSize)
+	// The first instances drained fill the warm pool.
+	warm := d.WarmPoolSize
+	if warm > toDrain {
+		warm = toDrain
+	}
+	for _, arn := range containerInstances[:warm] {
+		plan.WarmPool = append(plan.WarmPool, ec2IDs[*arn])
+	}
+	switch {
+	case d.InstanceFlip:
+		// The ASG replaces what's terminated, and the task count is restored.
+		plan.FinalASGCapacity = plan.ASGCapacity
+		plan.FinalTaskCount = plan.TaskCount
+	case len(d.InstanceWeights) > 0:
+		units, err := d.weightedCapacity(ctx, containerInstances[warm:])
+		if err != nil {
+			return nil, err
+		}
+		plan.FinalASGCapacity = plan.ASGCapacity - units
+	default:
+		plan.FinalASGCapacity = d.instanceTarget() + int64(warm)
+	}
+
+	if plan.InsufficientCapacity, err = d.checkReschedulingCapacity(ctx, service, containerInstances, tasks); err != nil {
+		return nil, err
+	}
+	if plan.InsufficientCapacity != "" {
+		d.warnf("%s", plan.InsufficientCapacity)
+	}
+
+	plan.EstimatedDuration = d.estimateRunDuration(service, len(plan.Batches))
+	return plan, nil
+}
+
+// Returns the tasks running on each of the given container instances.
+func (d *DownScaler) tasksOnContainerInstances(ctx context.Context, containerInstances []*string) (map[string][]*ecs.Task, error) {
+	var taskArns []*string
+	fn := func(page *ecs.ListTasksOutput, isLastPage bool) bool {
+		taskArns = append(taskArns, page.TaskArns...)
+		return page.NextToken != nil
+	}
+	for _, arn := range containerInstances {
+		err := d.ecs.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+			Cluster:           &d.Cluster,
+			ContainerInstance: arn,
+		}, fn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot list tasks on %s", *arn)
+		}
+	}
+	described, err := d.describeTasks(ctx, taskArns)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make(map[string][]*ecs.Task)
+	for _, task := range described {
+		arn := aws.StringValue(task.ContainerInstanceArn)
+		tasks[arn] = append(tasks[arn], task)
+	}
+	return tasks, nil
+}
+
+// Counts the service's tasks among those running on the given container
+// instances.
+func countServiceTasks(service string, tasks map[string][]*ecs.Task, containerInstances []*string) int64 {
+	var count int64
+	for _, arn := range containerInstances {
+		for _, task := range tasks[*arn] {
+			if aws.StringValue(task.Group) == serviceGroup(service) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Returns the task group of the service's tasks. ECS names it after the
+// service, so a service given by ARN is reduced to its name.
+func serviceGroup(service string) string {
+	return "service:" + nameFromArn(service)
+}
+
+// Roughly checks that the container instances left after draining have the
+// CPU and memory to take the disrupted tasks of other services, along with
+// however many tasks the service still needs beyond those already running on
+// them. Returns why they don't, or "" when they do.
+func (d *DownScaler) checkReschedulingCapacity(ctx context.Context, service *ecs.Service, draining []*string, tasks map[string][]*ecs.Task) (string, error) {
+	requirements := make(map[string]taskRequirements)
+	requirement := func(taskDefinition string) (taskRequirements, error) {
+		if req, ok := requirements[taskDefinition]; ok {
+			return req, nil
+		}
+		req, err := d.taskRequirements(ctx, taskDefinition)
+		requirements[taskDefinition] = req
+		return req, err
+	}
+
+	var needCPU, needMemory, rescheduled int64
+	for _, arn := range draining {
+		for _, task := range tasks[*arn] {
+			if aws.StringValue(task.Group) == serviceGroup(d.Service) {
+				continue
+			}
+			req, err := requirement(aws.StringValue(task.TaskDefinitionArn))
+			if err != nil {
+				return "", err
+			}
+			needCPU += req.cpu
+			needMemory += req.memory
+			rescheduled++
+		}
+	}
+
+	serviceTasks, err := d.listServiceTasks(ctx)
+	if err != nil {
+		return "", err
+	}
+	isDraining := make(map[string]bool)
+	for _, arn := range draining {
+		isDraining[*arn] = true
+	}
+	var staying int64
+	for _, task := range serviceTasks {
+		if !isDraining[aws.StringValue(task.ContainerInstanceArn)] {
+			staying++
+		}
+	}
+	if missing := d.DesiredCount - staying; missing > 0 {
+		req, err := requirement(aws.StringValue(service.TaskDefinition))
+		if err != nil {
+			return "", err
+		}
+		needCPU += missing * req.cpu
+		needMemory += missing * req.memory
+		rescheduled += missing
+	}
+	if rescheduled == 0 {
+		return "", nil
+	}
+
+	allArns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	var survivorArns []*string
+	for _, arn := range allArns {
+		if !isDraining[*arn] {
+			survivorArns = append(survivorArns, arn)
+		}
+	}
+	survivors, err := d.describeContainerInstances(ctx, survivorArns)
+	if err != nil {
+		return "", err
+	}
+	var freeCPU, freeMemory int64
+	for _, ci := range survivors {
+		freeCPU += resourceValue(ci.RemainingResources, "CPU")
+		freeMemory += resourceValue(ci.RemainingResources, "MEMORY")
+	}
+
+	if needCPU > freeCPU || needMemory > freeMemory {
+		return fmt.Sprintf("%d tasks need rescheduling onto the %d remaining container instances, needing %d CPU units and %d MiB, but only %d CPU units and %d MiB are free; some tasks may not be placed",
+			rescheduled, len(survivors), needCPU, needMemory, freeCPU, freeMemory), nil
+	}
+	log.Printf("%d tasks need rescheduling, needing %d CPU units and %d MiB of the %d CPU units and %d MiB free on the remaining container instances",
+		rescheduled, needCPU, needMemory, freeCPU, freeMemory)
+	return "", nil
+}
@@ -0,0 +1,27 @@
+package downscaler
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestCountServiceTasks(t *testing.T) {
+	tasks := map[string][]*ecs.Task{
+		fakeContainerArn("i-01"): {
+			{Group: aws.String("service:web")},
+			{Group: aws.String("service:web")},
+			{Group: aws.String("service:worker")},
+		},
+		fakeContainerArn("i-02"): {
+			{Group: aws.String("service:web")},
+			{Group: aws.String("family:web")},
+		},
+	}
+	for _, service := range []string{"web", "arn:aws:ecs:us-east-1:123456789012:service/cluster/web"} {
+		if got := countServiceTasks(service, tasks, fakeContainerArns("i-01", "i-02")); got != 3 {
+			t.Errorf("countServiceTasks(%q) = %d; want 3", service, got)
+		}
+	}
+}
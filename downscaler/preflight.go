@@ -0,0 +1,84 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// Checks, concurrently, that the ASG and whichever of the cluster and service
+// are given exist, so every bad input is reported at once before the run
+// starts. A single problem is returned as is.
+func (d *DownScaler) preflight(ctx context.Context) error {
+	checks := []func() error{
+		func() error {
+			_, err := d.describeASG(ctx)
+			return err
+		},
+	}
+	if d.Cluster != "" {
+		checks = append(checks, func() error {
+			return d.checkClusterExists(ctx)
+		})
+	}
+	if d.Cluster != "" && d.Service != "" {
+		checks = append(checks, func() error {
+			_, err := d.ecsService(ctx)
+			if nf, ok := err.(*NotFoundError); ok && nf.Kind == "cluster" {
+				// Already reported by the cluster's check.
+				return nil
+			}
+			return err
+		})
+	}
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() error) {
+			defer wg.Done()
+			errs[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+
+	var failures []error
+	for _, err := range errs {
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+	switch len(failures) {
+	case 0:
+		return nil
+	case 1:
+		return failures[0]
+	default:
+		msgs := make([]string, len(failures))
+		for i, err := range failures {
+			msgs[i] = err.Error()
+		}
+		return fmt.Errorf("%d inputs are invalid:\n\t%s", len(failures), strings.Join(msgs, "\n\t"))
+	}
+}
+
+func (d *DownScaler) checkClusterExists(ctx context.Context) error {
+	out, err := d.ecs.DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+		Clusters: []*string{&d.Cluster},
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot describe cluster")
+	}
+	for _, cluster := range out.Clusters {
+		if aws.StringValue(cluster.Status) == "ACTIVE" {
+			return nil
+		}
+	}
+	return d.clusterNotFound(ctx, nil)
+}
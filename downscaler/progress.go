@@ -0,0 +1,42 @@
+package downscaler
+
+import "context"
+
+// Phase is the step of a run in progress.
+type Phase string
+
+const (
+	PhaseDiscovering     Phase = "discovering"
+	PhaseDraining        Phase = "draining"
+	PhaseScalingService  Phase = "scaling-service"
+	PhaseScalingASG      Phase = "scaling-asg"
+	PhaseWaitingForDrain Phase = "waiting-for-drain"
+	PhaseTerminating     Phase = "terminating"
+	PhaseFinalizing      Phase = "finalizing"
+	PhaseDone            Phase = "done"
+)
+
+// Progress describes how far along a run is. It is passed to
+// Config.ProgressFunc every time the run changes phase.
+type Progress struct {
+	Phase Phase
+
+	// The number of batches the run will drain, once known, and the batch
+	// currently being drained, counting from 1.
+	TotalBatches int
+	Batch        int
+
+	// The number of container instances drained and terminated so far.
+	Drained    int
+	Terminated int
+}
+
+func (d *DownScaler) reportProgress(phase Phase) {
+	d.progress.Phase = phase
+	d.progress.Terminated = len(d.result.Terminated)
+	if d.ProgressFunc != nil {
+		d.ProgressFunc(d.progress)
+	}
+	d.putProgressEvent(context.Background())
+	d.flushLogs(context.Background())
+}
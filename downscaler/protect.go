@@ -0,0 +1,70 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Returns the ProtectNewest most recently launched of the container instances,
+// which must never be drained.
+func (d *DownScaler) newestContainerInstances(ctx context.Context, containerArns []*string) (map[string]bool, error) {
+	protected := make(map[string]bool)
+	if d.ProtectNewest <= 0 {
+		return protected, nil
+	}
+
+	instances, err := d.describeContainerEC2Instances(ctx, containerArns)
+	if err != nil {
+		return nil, err
+	}
+	launched := make(map[string]time.Time)
+	arns := make([]string, 0, len(instances))
+	for arn, instance := range instances {
+		launched[arn] = aws.TimeValue(instance.LaunchTime)
+		arns = append(arns, arn)
+	}
+	sort.Slice(arns, func(i, j int) bool {
+		return launched[arns[i]].After(launched[arns[j]])
+	})
+
+	if len(arns) > d.ProtectNewest {
+		arns = arns[:d.ProtectNewest]
+	}
+	for _, arn := range arns {
+		protected[arn] = true
+		fmt.Printf(" -> Protecting %s: launched %s\n", arn, launched[arn].Format(time.RFC3339))
+	}
+	return protected, nil
+}
+
+// Returns the container instances whose EC2 instance has a tag with one of
+// the RequireTagAbsent keys, and the key of the tag that blocks each.
+func (d *DownScaler) tagLockedContainerInstances(ctx context.Context, containerArns []*string) (map[string]string, error) {
+	locked := make(map[string]string)
+	if len(d.RequireTagAbsent) == 0 {
+		return locked, nil
+	}
+
+	instances, err := d.describeContainerEC2Instances(ctx, containerArns)
+	if err != nil {
+		return nil, err
+	}
+	blocking := make(map[string]bool)
+	for _, key := range d.RequireTagAbsent {
+		blocking[key] = true
+	}
+	for arn, instance := range instances {
+		for _, tag := range instance.Tags {
+			if key := aws.StringValue(tag.Key); blocking[key] {
+				locked[arn] = key
+				fmt.Printf(" -> Excluding %s (%s): tagged %s\n", arn, aws.StringValue(instance.InstanceId), key)
+				break
+			}
+		}
+	}
+	return locked, nil
+}
@@ -0,0 +1,109 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// RampStep is one step of a RampSchedule: a scale-down to DesiredCount, then
+// a pause of Hold before the next step.
+type RampStep struct {
+	DesiredCount int64
+	Hold         time.Duration
+}
+
+// Runs the whole scale-down once per step of RampSchedule, in place of
+// DesiredCount, holding between steps while watching the service's health.
+// The ramp stops at the first step that fails or leaves the service unhealthy.
+func (d *DownScaler) runRamp(ctx context.Context) (*Result, error) {
+	result := &Result{Reason: d.Reason}
+	for i, step := range d.RampSchedule {
+		config := *d.Config
+		config.DesiredCount = step.DesiredCount
+		config.RampSchedule = nil
+		config.JUnitReportPath = reportPathFor(d.JUnitReportPath, fmt.Sprintf("step%d", i+1))
+		config.CSVReportPath = reportPathFor(d.CSVReportPath, fmt.Sprintf("step%d", i+1))
+		if i < len(d.RampSchedule)-1 {
+			// Only the last step keeps a warm pool, so earlier ones don't
+			// leave instances the ASG would then count as capacity.
+			config.WarmPoolSize = 0
+		}
+
+		ramp := *d
+		ramp.Config = &config
+
+		fmt.Println(strings.Repeat("=", 80))
+		log.Printf("Ramp step %d of %d: scaling down to %d", i+1, len(d.RampSchedule), step.DesiredCount)
+
+		r, err := ramp.RunWithContext(ctx)
+		if r != nil {
+			result.RampSteps = append(result.RampSteps, r)
+			result.merge(r, fmt.Sprintf("step %d", i+1))
+		}
+		if err != nil {
+			result.Recorded = d.result.Recorded
+			return result, errors.Wrapf(err, "ramp step %d of %d failed", i+1, len(d.RampSchedule))
+		}
+
+		if i == len(d.RampSchedule)-1 || step.Hold <= 0 || d.DryRun || d.Record {
+			continue
+		}
+		log.Printf("Holding at %d for %s", step.DesiredCount, step.Hold)
+		if err := d.holdWatchingHealth(ctx, step.Hold); err != nil {
+			result.Recorded = d.result.Recorded
+			return result, errors.Wrapf(err, "aborting ramp after step %d of %d", i+1, len(d.RampSchedule))
+		}
+	}
+
+	// Every step's requests were recorded by the clients, which are shared.
+	result.Recorded = d.result.Recorded
+	return result, nil
+}
+
+// Waits for the given duration, failing if HealthCheckURL fails its checks or
+// the service runs fewer tasks than desired for HealthCheckFailures checks in
+// a row.
+func (d *DownScaler) holdWatchingHealth(ctx context.Context, hold time.Duration) error {
+	limit := d.HealthCheckFailures
+	if limit < 1 {
+		limit = 1
+	}
+	deadline := time.Now().Add(hold)
+	short := 0
+	for time.Now().Before(deadline) {
+		wait := healthCheckInterval
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+
+		if d.HealthCheckURL != "" {
+			if err := d.checkHealth(ctx); err != nil {
+				return err
+			}
+		}
+		service, err := d.ecsService(ctx)
+		if err != nil {
+			return err
+		}
+		running, desired := aws.Int64Value(service.RunningCount), aws.Int64Value(service.DesiredCount)
+		if running >= desired {
+			short = 0
+			continue
+		}
+		short++
+		log.Printf("Service %s is running %d of %d desired tasks (%d/%d)", d.Service, running, desired, short, limit)
+		if short >= limit {
+			return fmt.Errorf("service %s is running only %d of %d desired tasks", d.Service, running, desired)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package downscaler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// RecordedCall is an AWS request that changes something, which a Record run
+// printed instead of sending.
+type RecordedCall struct {
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+
+	// The request's input, in the same shape as the AWS CLI's
+	// --cli-input-json.
+	Input json.RawMessage `json:"input"`
+}
+
+// Reads are sent as usual. Everything else changes something, and isn't.
+func isReadOnlyOperation(name string) bool {
+	for _, prefix := range []string{"Describe", "List", "Get", "Simulate"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs after a request is built. In Record runs, requests that change
+// something are recorded and answered locally instead of being sent.
+func (d *DownScaler) recordRequest(r *request.Request) {
+	// Sending the run's own logs doesn't change anything it acts on.
+	if !d.Record || isReadOnlyOperation(r.Operation.Name) || r.ClientInfo.ServiceName == cloudwatchlogs.ServiceName {
+		return
+	}
+
+	input, err := jsonutil.BuildJSON(r.Params)
+	if err != nil {
+		r.Error = err
+		return
+	}
+	fmt.Printf("Would call %s %s: %s\n", r.ClientInfo.ServiceName, r.Operation.Name, input)
+	d.result.Recorded = append(d.result.Recorded, RecordedCall{
+		Service:   r.ClientInfo.ServiceName,
+		Operation: r.Operation.Name,
+		Input:     input,
+	})
+
+	if err := d.simulateOutput(r); err != nil {
+		r.Error = err
+		return
+	}
+	r.Handlers.Sign.Clear()
+	r.Handlers.Send.Clear()
+	r.Handlers.UnmarshalMeta.Clear()
+	r.Handlers.ValidateResponse.Clear()
+	r.Handlers.Unmarshal.Clear()
+	r.HTTPResponse = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+}
+
+// Fills in the outputs the rest of the run relies on, as the request would
+// have returned them.
+func (d *DownScaler) simulateOutput(r *request.Request) error {
+	switch out := r.Data.(type) {
+	case *ecs.UpdateServiceOutput:
+		in := r.Params.(*ecs.UpdateServiceInput)
+		services, err := d.ecs.DescribeServicesWithContext(r.Context(), &ecs.DescribeServicesInput{
+			Cluster:  in.Cluster,
+			Services: []*string{in.Service},
+		})
+		if err != nil {
+			return err
+		}
+		for _, service := range services.Services {
+			out.Service = service
+			if in.DesiredCount != nil {
+				out.Service.DesiredCount = in.DesiredCount
+			}
+		}
+
+	case *ecs.UpdateContainerInstancesStateOutput:
+		in := r.Params.(*ecs.UpdateContainerInstancesStateInput)
+		instances, err := d.ecs.DescribeContainerInstancesWithContext(r.Context(), &ecs.DescribeContainerInstancesInput{
+			Cluster:            in.Cluster,
+			ContainerInstances: in.ContainerInstances,
+		})
+		if err != nil {
+			return err
+		}
+		for _, ci := range instances.ContainerInstances {
+			ci.Status = aws.String(aws.StringValue(in.Status))
+		}
+		out.ContainerInstances = instances.ContainerInstances
+		out.Failures = instances.Failures
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package downscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// A capacity schedule read from ScheduleS3URI, e.g.
+//
+//	{
+//	  "timezone": "America/Los_Angeles",
+//	  "entries": [
+//	    {"window": "22:00-06:00", "desired_count": 5},
+//	    {"window": "06:00-22:00", "desired_count": 20}
+//	  ]
+//	}
+//
+// The first entry whose window contains the current time applies. The
+// timezone defaults to UTC.
+type schedule struct {
+	Timezone string          `json:"timezone"`
+	Entries  []scheduleEntry `json:"entries"`
+}
+
+type scheduleEntry struct {
+	Window       string `json:"window"`
+	DesiredCount int64  `json:"desired_count"`
+}
+
+// Splits an s3://bucket/key URI.
+func parseS3URI(uri string) (bucket, key string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URI %q: expected s3://bucket/key", uri)
+	}
+	return u.Host, key, nil
+}
+
+// Parses a schedule and returns the desired count of its entry for now, and
+// the entry's window.
+func parseSchedule(data []byte, now time.Time) (int64, TimeWindow, error) {
+	var s schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, TimeWindow{}, errors.Wrap(err, "cannot parse schedule")
+	}
+	timezone := s.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return 0, TimeWindow{}, fmt.Errorf("invalid schedule timezone %q: %s", timezone, err)
+	}
+
+	local := now.In(loc)
+	for i, entry := range s.Entries {
+		w, err := ParseTimeWindow(entry.Window)
+		if err != nil {
+			return 0, TimeWindow{}, errors.Wrapf(err, "schedule entry %d", i+1)
+		}
+		if entry.DesiredCount <= 0 {
+			return 0, TimeWindow{}, fmt.Errorf("schedule entry %d must have a positive desired count", i+1)
+		}
+		ok, err := w.contains(local)
+		if err != nil {
+			return 0, TimeWindow{}, err
+		}
+		if ok {
+			return entry.DesiredCount, w, nil
+		}
+	}
+	return 0, TimeWindow{}, fmt.Errorf("no schedule entry covers %s (%s)", local.Format("15:04"), timezone)
+}
+
+// Sets DesiredCount from the schedule at ScheduleS3URI. If the object is
+// missing, can't be parsed or has no entry for now, DesiredCount is kept,
+// with a warning.
+func (d *DownScaler) applySchedule(ctx context.Context, now time.Time) error {
+	bucket, key, err := parseS3URI(d.ScheduleS3URI)
+	if err != nil {
+		return err
+	}
+	fallBack := func(reason string) error {
+		if d.DesiredCount <= 0 {
+			return fmt.Errorf("cannot use schedule %s: %s; and there is no desired count to fall back to", d.ScheduleS3URI, reason)
+		}
+		d.warnf("cannot use schedule %s: %s; falling back to the desired count of %d", d.ScheduleS3URI, reason, d.DesiredCount)
+		return nil
+	}
+
+	out, err := d.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == s3.ErrCodeNoSuchBucket) {
+		return fallBack("the object doesn't exist")
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot get schedule %s", d.ScheduleS3URI)
+	}
+	defer out.Body.Close()
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return errors.Wrapf(err, "cannot read schedule %s", d.ScheduleS3URI)
+	}
+
+	count, window, err := parseSchedule(data, now)
+	if err != nil {
+		return fallBack(err.Error())
+	}
+	log.Printf("Scaling down to %d, scheduled for %s by %s", count, window, d.ScheduleS3URI)
+	d.DesiredCount = count
+	return nil
+}
@@ -0,0 +1,164 @@
+package downscaler
+
+import "encoding/json"
+
+// SchemaVersion is the version of the JSON encoding of Result and Plan. It is
+// only bumped by changes that break parsers; adding fields doesn't bump it.
+const SchemaVersion = 1
+
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	durations := make([]float64, len(r.BatchDurations))
+	for i, duration := range r.BatchDurations {
+		durations[i] = duration.Seconds()
+	}
+	return json.Marshal(struct {
+		SchemaVersion  int       `json:"schema_version"`
+		BatchDurations []float64 `json:"batch_durations_seconds"`
+		*result
+	}{SchemaVersion, durations, (*result)(&r)})
+}
+
+func (p Plan) MarshalJSON() ([]byte, error) {
+	type plan Plan
+	return json.Marshal(struct {
+		SchemaVersion     int     `json:"schema_version"`
+		EstimatedDuration float64 `json:"estimated_duration_seconds"`
+		*plan
+	}{SchemaVersion, p.EstimatedDuration.Seconds(), (*plan)(&p)})
+}
+
+// JSONSchema returns the JSON Schema of the JSON encoding of Result, which
+// includes Plan.
+func JSONSchema() string {
+	return jsonSchema
+}
+
+const jsonSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/maikxchd/ecs-down/schema/result-v1.json",
+  "title": "ecs-down result",
+  "type": "object",
+  "required": ["schema_version", "batch_durations_seconds", "terminated", "force_terminated", "drain_failures", "no_change", "instances", "drained", "skipped", "warnings"],
+  "definitions": {
+    "asg_sizes": {
+      "type": "object",
+      "required": ["desired_capacity", "min_size", "max_size"],
+      "properties": {
+        "desired_capacity": {"type": "integer"},
+        "min_size": {"type": "integer"},
+        "max_size": {"type": "integer"}
+      }
+    }
+  },
+  "properties": {
+    "schema_version": {"const": 1},
+    "terminated": {"type": ["array", "null"], "items": {"type": "string"}, "description": "EC2 instance IDs that were terminated"},
+    "force_terminated": {"type": ["array", "null"], "items": {"type": "string"}, "description": "EC2 instance IDs terminated while still running tasks"},
+    "drain_failures": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "required": ["container_instance_arn", "reason"],
+        "properties": {
+          "container_instance_arn": {"type": "string"},
+          "reason": {"type": "string"}
+        }
+      }
+    },
+    "no_change": {"type": "boolean", "description": "Whether the cluster was already at the desired size"},
+    "instances": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "required": ["container_instance_arn", "ec2_instance_id", "instance_type", "availability_zone", "launch_time", "reason"],
+        "properties": {
+          "container_instance_arn": {"type": "string"},
+          "ec2_instance_id": {"type": "string"},
+          "instance_type": {"type": "string"},
+          "availability_zone": {"type": "string"},
+          "launch_time": {"type": "string", "format": "date-time"},
+          "reason": {"type": "string", "description": "Why the instance was selected"}
+        }
+      }
+    },
+    "reason": {"type": "string", "description": "The run's human-supplied justification, if given"},
+    "drained": {"type": ["array", "null"], "items": {"type": "string"}, "description": "Container instance ARNs that were set to DRAINING"},
+    "warm_pool": {"type": "array", "items": {"type": "string"}, "description": "EC2 instance IDs of drained instances kept running instead of terminated; only set with WarmPoolSize"},
+    "skipped": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "required": ["container_instance_arn", "reason"],
+        "properties": {
+          "container_instance_arn": {"type": "string"},
+          "reason": {"type": "string"}
+        }
+      }
+    },
+    "warnings": {"type": ["array", "null"], "items": {"type": "string"}},
+    "batch_durations_seconds": {"type": "array", "items": {"type": "number"}, "description": "How long each batch took, in order"},
+    "failed_batch": {"type": "integer", "description": "The batch, counting from 1, that failed the run"},
+    "expected_asg_sizes": {"$ref": "#/definitions/asg_sizes", "description": "The sizes the ASG was finally set to"},
+    "observed_asg_sizes": {"$ref": "#/definitions/asg_sizes", "description": "The ASG's sizes once the run was done"},
+    "healthy_instances": {"type": "integer", "description": "ACTIVE, connected container instances once the run was done; only set with MinHealthyAfter"},
+    "plan": {
+      "type": "object",
+      "description": "The batches a dry run would drain; only set by dry runs",
+      "required": ["schema_version", "cluster", "service", "asg", "batches", "task_count", "final_task_count", "instance_flip", "asg_capacity", "asg_min_size", "asg_max_size", "final_asg_capacity", "disrupted_tasks", "estimated_duration_seconds"],
+      "properties": {
+        "schema_version": {"const": 1},
+        "cluster": {"type": "string"},
+        "service": {"type": "string"},
+        "asg": {"type": "string"},
+        "task_count": {"type": "integer", "description": "The service's desired task count before the run"},
+        "final_task_count": {"type": "integer", "description": "The service's desired task count after the run"},
+        "instance_flip": {"type": "boolean"},
+        "asg_capacity": {"type": "integer"},
+        "asg_min_size": {"type": "integer"},
+        "asg_max_size": {"type": "integer"},
+        "final_asg_capacity": {"type": "integer", "description": "The ASG's desired capacity, minimum and maximum size after the run"},
+        "warm_pool": {"type": "array", "items": {"type": "string"}, "description": "EC2 instance IDs the run would keep in its warm pool"},
+        "disrupted_tasks": {"type": "integer", "description": "The number of tasks running on the drained instances"},
+        "insufficient_capacity": {"type": "string", "description": "Why the remaining instances look too small to take the rescheduled tasks"},
+        "estimated_duration_seconds": {"type": "number"},
+        "batches": {
+          "type": ["array", "null"],
+          "items": {
+            "type": "object",
+            "required": ["container_instance_arns", "ec2_instance_ids", "task_count", "tasks"],
+            "properties": {
+              "container_instance_arns": {"type": "array", "items": {"type": "string"}},
+              "ec2_instance_ids": {"type": "array", "items": {"type": "string"}},
+              "task_count": {"type": "integer", "description": "The service's desired task count once the batch is drained"},
+              "tasks": {
+                "type": ["array", "null"],
+                "items": {
+                  "type": "object",
+                  "required": ["task_arn", "task_definition_arn", "group", "container_instance_arn"],
+                  "properties": {
+                    "task_arn": {"type": "string"},
+                    "task_definition_arn": {"type": "string"},
+                    "group": {"type": "string"},
+                    "container_instance_arn": {"type": "string"}
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "ramp_steps": {
+      "type": "array",
+      "description": "Results of each step of a ramp, in order",
+      "items": {"$ref": "#"}
+    },
+    "clusters": {
+      "type": "object",
+      "description": "Results of each cluster in a multi-cluster run, keyed by cluster name",
+      "additionalProperties": {"$ref": "#"}
+    }
+  }
+}
+`
@@ -0,0 +1,151 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScoreWeights weighs what makes a candidate worth draining, for ordering
+// candidates by a score instead of the built-in priorities. Each factor is
+// normalized across the candidates to between 0, for the candidate least
+// worth draining by it, and 1, for the one most worth draining, and the
+// score is the weighted sum of the factors. Candidates are drained highest
+// score first.
+type ScoreWeights struct {
+	// Older instances, by launch time, score higher.
+	Age float64
+	// Instances running fewer tasks score higher.
+	TaskCount float64
+	// Instances with less of their memory reserved score higher.
+	Reservation float64
+	// Instances with older ECS agents score higher.
+	AgentVersion float64
+}
+
+// DefaultScoreWeights orders candidates much like the built-in priorities:
+// old agents first, then little reserved memory, then few tasks, with age
+// breaking ties. Each weight is twice the next, so a factor mostly wins
+// over all those weighted below it.
+var DefaultScoreWeights = ScoreWeights{AgentVersion: 8, Reservation: 4, TaskCount: 2, Age: 1}
+
+// Scales values to between 0 and 1, the lowest value to 1 and the highest to
+// 0. All equal values scale to 0, since they don't tell candidates apart.
+func normalizeLowestFirst(values []float64) []float64 {
+	normalized := make([]float64, len(values))
+	if len(values) == 0 {
+		return normalized
+	}
+	lowest, highest := values[0], values[0]
+	for _, v := range values {
+		if v < lowest {
+			lowest = v
+		}
+		if v > highest {
+			highest = v
+		}
+	}
+	if highest == lowest {
+		return normalized
+	}
+	for i, v := range values {
+		normalized[i] = (highest - v) / (highest - lowest)
+	}
+	return normalized
+}
+
+// Compares dotted versions such as "1.39.0" numerically, returning -1, 0 or
+// 1. Parts that aren't numbers count as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Returns each candidate's score with the given weights, in the order of
+// candidates.
+func scoreCandidates(candidates []*CandidateInstance, weights ScoreWeights) []float64 {
+	ages := make([]float64, len(candidates))
+	tasks := make([]float64, len(candidates))
+	reservations := make([]float64, len(candidates))
+	for i, c := range candidates {
+		ages[i] = float64(c.LaunchTime.Unix())
+		tasks[i] = float64(c.RunningTasksCount)
+		reservations[i] = c.MemoryReservedPercent
+	}
+
+	// Agent versions are ranked rather than scaled, since their numbers
+	// don't measure anything.
+	var versions []string
+	for _, c := range candidates {
+		versions = append(versions, c.AgentVersion)
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+	agents := make([]float64, len(candidates))
+	for i, c := range candidates {
+		agents[i] = float64(sort.Search(len(versions), func(j int) bool {
+			return compareVersions(versions[j], c.AgentVersion) >= 0
+		}))
+	}
+
+	scores := make([]float64, len(candidates))
+	for _, factor := range []struct {
+		values []float64
+		weight float64
+	}{
+		{ages, weights.Age},
+		{tasks, weights.TaskCount},
+		{reservations, weights.Reservation},
+		{agents, weights.AgentVersion},
+	} {
+		for i, v := range normalizeLowestFirst(factor.values) {
+			scores[i] += factor.weight * v
+		}
+	}
+	return scores
+}
+
+// Orders the candidates by their score with ScoreWeights, overriding the
+// built-in priorities. Candidates with equal scores keep their priority order.
+func (d *DownScaler) sortCandidatesByScore(ctx context.Context, arns []*string) ([]*string, error) {
+	described, err := d.describeCandidates(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	var sorted []*string
+	var candidates []*CandidateInstance
+	for _, arn := range arns {
+		if candidate, ok := described[*arn]; ok {
+			sorted = append(sorted, arn)
+			candidates = append(candidates, candidate)
+		}
+	}
+	scores := scoreCandidates(candidates, *d.ScoreWeights)
+	byArn := make(map[string]float64, len(sorted))
+	for i, arn := range sorted {
+		byArn[*arn] = scores[i]
+		d.selectionReasons[*arn] = fmt.Sprintf("score %.2f", scores[i])
+		d.explainf("\t%s scored %.2f", *arn, scores[i])
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return byArn[*sorted[i]] > byArn[*sorted[j]]
+	})
+	return sorted, nil
+}
@@ -0,0 +1,72 @@
+package downscaler
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+// Reports whether a spot request's status code means EC2 is about to
+// interrupt its instance: "marked-for-termination", "marked-for-stop" or
+// "marked-for-hibernation".
+func spotInterruptionPending(code string) bool {
+	return strings.HasPrefix(code, "marked-for-")
+}
+
+// Returns the ARNs of container instances on spot instances that EC2 has
+// marked for interruption, and logs each of them. EC2's API doesn't report
+// rebalance recommendations, which only reach the instance's metadata and
+// EventBridge, so the spot request's status is what's checked.
+func (d *DownScaler) findContainerInstancesMarkedForInterruption(ctx context.Context) ([]*string, error) {
+	arns, err := d.listContainerInstances(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.describeContainerEC2Instances(ctx, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	requestToContainerArn := make(map[string]*string)
+	var requestIDs []string
+	for _, arn := range arns {
+		instance, ok := instances[*arn]
+		if !ok || aws.StringValue(instance.InstanceLifecycle) != ec2.InstanceLifecycleTypeSpot || instance.SpotInstanceRequestId == nil {
+			continue
+		}
+		requestToContainerArn[*instance.SpotInstanceRequestId] = arn
+		requestIDs = append(requestIDs, *instance.SpotInstanceRequestId)
+	}
+	if len(requestIDs) == 0 {
+		return nil, nil
+	}
+
+	var marked []*string
+	fn := func(page *ec2.DescribeSpotInstanceRequestsOutput, hasNext bool) bool {
+		for _, request := range page.SpotInstanceRequests {
+			if request.Status == nil || !spotInterruptionPending(aws.StringValue(request.Status.Code)) {
+				continue
+			}
+			arn, ok := requestToContainerArn[aws.StringValue(request.SpotInstanceRequestId)]
+			if !ok {
+				continue
+			}
+			log.Printf("Spot instance %s of %s is %s: %s", aws.StringValue(request.InstanceId), *arn, aws.StringValue(request.Status.Code), aws.StringValue(request.Status.Message))
+			marked = append(marked, arn)
+		}
+		return page.NextToken != nil
+	}
+	for _, page := range paginateStringArray(requestIDs, 200) {
+		err := d.ec2.DescribeSpotInstanceRequestsPagesWithContext(ctx, &ec2.DescribeSpotInstanceRequestsInput{
+			SpotInstanceRequestIds: aws.StringSlice(page),
+		}, fn)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot describe spot instance requests")
+		}
+	}
+	return marked, nil
+}
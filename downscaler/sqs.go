@@ -0,0 +1,48 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+// How often to check the queue's in-flight messages while waiting for them.
+const sqsPollInterval = 10 * time.Second
+
+// Waits until SQSQueueURL has at most SQSMaxInFlight messages in flight, so
+// workers on drained instances aren't terminated mid-message. Fails if it
+// still has more after SQSSettleTimeout.
+func (d *DownScaler) waitForQueueToSettle(ctx context.Context) error {
+	deadline := time.Now().Add(d.SQSSettleTimeout)
+	for {
+		out, err := d.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       &d.SQSQueueURL,
+			AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible}),
+		})
+		if err != nil {
+			return errors.Wrap(err, "cannot get queue attributes")
+		}
+		inFlight, err := strconv.ParseInt(aws.StringValue(out.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible]), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "cannot parse in-flight messages of %s", d.SQSQueueURL)
+		}
+
+		if inFlight <= d.SQSMaxInFlight {
+			log.Printf("%d messages in flight on %s", inFlight, d.SQSQueueURL)
+			return nil
+		}
+		if d.SQSSettleTimeout > 0 && !time.Now().Before(deadline) {
+			return fmt.Errorf("%d messages still in flight on %s after %s, more than %d", inFlight, d.SQSQueueURL, d.SQSSettleTimeout, d.SQSMaxInFlight)
+		}
+		log.Printf("Waiting for the %d messages in flight on %s to be processed...", inFlight, d.SQSQueueURL)
+		if err := sleepContext(ctx, sqsPollInterval); err != nil {
+			return err
+		}
+	}
+}
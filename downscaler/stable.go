@@ -0,0 +1,88 @@
+package downscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// ErrStableTimeout is the cause of errors waiting for the service to become
+// stable that only ran out of time. Trying again may succeed.
+var ErrStableTimeout = errors.New("timed out waiting for the service to become stable")
+
+// ErrDeploymentFailed is the cause of errors waiting for the service to become
+// stable because its deployment failed, e.g. tasks can't be placed or keep
+// failing health checks. Trying again won't help.
+var ErrDeploymentFailed = errors.New("service deployment failed")
+
+// Service event messages that mean a deployment is failing rather than slow.
+var deploymentFailureEvents = []string{
+	"unable to place a task",
+	"failed container health checks",
+	"is unhealthy",
+	"failed to launch a task",
+	"rolling back",
+	"deployment failed",
+}
+
+// Waits for the service to become stable, waiting again up to
+// StableWaitRetries times if that times out. Errors are caused by
+// ErrStableTimeout or ErrDeploymentFailed when the waiter gives up.
+func (d *DownScaler) waitUntilServiceStable(ctx context.Context, since time.Time) error {
+	for attempt := 0; ; attempt++ {
+		err := d.ecs.WaitUntilServicesStableWithContext(ctx, &ecs.DescribeServicesInput{
+			Cluster:  &d.Cluster,
+			Services: []*string{&d.Service},
+		})
+		if err == nil {
+			return nil
+		}
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != request.WaiterResourceNotReadyErrorCode {
+			return err
+		}
+
+		err = d.classifyStableWaitFailure(ctx, aerr, since)
+		if errors.Cause(err) != ErrStableTimeout || attempt >= d.StableWaitRetries {
+			return err
+		}
+		d.warnf("%s; waiting again (%d/%d)", err, attempt+1, d.StableWaitRetries)
+	}
+}
+
+// Works out whether the waiter gave up because the deployment failed or just
+// because it ran out of attempts.
+func (d *DownScaler) classifyStableWaitFailure(ctx context.Context, waitErr awserr.Error, since time.Time) error {
+	service, err := d.ecsService(ctx)
+	if err != nil {
+		return err
+	}
+	if status := aws.StringValue(service.Status); status != "ACTIVE" {
+		return errors.Wrapf(ErrDeploymentFailed, "service %s is %s", d.Service, status)
+	}
+	// The waiter only fails outright when the service is gone or going.
+	if !strings.Contains(waitErr.Message(), "exceeded wait attempts") {
+		return errors.Wrapf(ErrDeploymentFailed, "service %s: %s", d.Service, waitErr.Message())
+	}
+
+	for _, event := range service.Events {
+		if aws.TimeValue(event.CreatedAt).Before(since) {
+			continue
+		}
+		message := aws.StringValue(event.Message)
+		for _, failure := range deploymentFailureEvents {
+			if strings.Contains(message, failure) {
+				return errors.Wrap(ErrDeploymentFailed, message)
+			}
+		}
+	}
+	return errors.Wrap(ErrStableTimeout, fmt.Sprintf("service %s has %d of %d tasks running, %d pending",
+		d.Service, aws.Int64Value(service.RunningCount), aws.Int64Value(service.DesiredCount), aws.Int64Value(service.PendingCount)))
+}
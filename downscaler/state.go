@@ -0,0 +1,98 @@
+package downscaler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Records which instances a run has already terminated, so a retried run
+// doesn't try to drain or terminate them again.
+//
+// Without a path the state only lives as long as the DownScaler. With a path
+// it is saved after every termination and loaded again by the next run.
+type runState struct {
+	path string
+
+	// EC2 instance ID -> container instance ARN.
+	Terminated map[string]string `json:"terminated"`
+}
+
+func loadRunState(path string) (*runState, error) {
+	state := &runState{
+		path:       path,
+		Terminated: make(map[string]string),
+	}
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read state file")
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse state file %s", path)
+	}
+	if state.Terminated == nil {
+		state.Terminated = make(map[string]string)
+	}
+	return state, nil
+}
+
+func (s *runState) isTerminated(ec2ID string) bool {
+	_, ok := s.Terminated[ec2ID]
+	return ok
+}
+
+func (s *runState) isContainerInstanceTerminated(containerArn string) bool {
+	for _, arn := range s.Terminated {
+		if arn == containerArn {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *runState) markTerminated(ec2ID, containerArn string) error {
+	s.Terminated[ec2ID] = containerArn
+	return s.save()
+}
+
+// Saves the state to its path, if it has one.
+func (s *runState) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(writeFileAtomically(s.path, data), "cannot write state file")
+}
+
+// Writes data to a temporary file and renames it over path, so an interrupted
+// write never leaves a truncated file behind.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
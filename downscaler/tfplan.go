@@ -0,0 +1,46 @@
+package downscaler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prints a plan in the style of a Terraform plan, for reviewers used to those.
+func printTerraformPlan(plan *Plan) {
+	fmt.Println("ecs-down will perform the following actions:")
+	fmt.Println()
+
+	changes, destroys := 0, 0
+	if plan.FinalTaskCount != plan.TaskCount {
+		fmt.Printf("  ~ aws_ecs_service.%s.desired_count: %d -> %d\n", plan.Service, plan.TaskCount, plan.FinalTaskCount)
+		changes++
+	}
+
+	var asgChanges []string
+	for _, attribute := range []struct {
+		name     string
+		from, to int64
+	}{
+		{"desired_capacity", plan.ASGCapacity, plan.FinalASGCapacity},
+		{"min_size", plan.ASGMinSize, plan.FinalASGCapacity},
+		{"max_size", plan.ASGMaxSize, plan.FinalASGCapacity},
+	} {
+		if attribute.from != attribute.to {
+			asgChanges = append(asgChanges, fmt.Sprintf("  ~ aws_autoscaling_group.%s.%s: %d -> %d", plan.ASG, attribute.name, attribute.from, attribute.to))
+		}
+	}
+	if len(asgChanges) > 0 && !plan.InstanceFlip {
+		fmt.Println(strings.Join(asgChanges, "\n"))
+		changes++
+	}
+
+	for _, batch := range plan.Batches {
+		for _, id := range batch.EC2InstanceIDs {
+			fmt.Printf("  - aws_instance.%s (terminated)\n", id)
+			destroys++
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Plan: 0 to add, %d to change, %d to destroy.\n", changes, destroys)
+}
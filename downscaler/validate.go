@@ -0,0 +1,69 @@
+package downscaler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Validate checks that the Config is coherent on its own: that its modes
+// don't conflict and its values are well formed. It makes no AWS calls, so
+// it can't tell whether the cluster, service or ASG exist. Run validates
+// the Config before anything else.
+func (c *Config) Validate() error {
+	if c.RequireReason && strings.TrimSpace(c.Reason) == "" {
+		return errors.New("a reason for the run is required; give one with -reason")
+	}
+	if len(c.AllowedWindows) > 0 {
+		if _, err := c.windowLocation(); err != nil {
+			return err
+		}
+	}
+	if err := c.checkFIPSEndpoints(); err != nil {
+		return err
+	}
+	if c.SortByAge && c.LIFO {
+		return errors.New("SortByAge and LIFO are mutually exclusive")
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("batch size %d must be at least 1", c.BatchSize)
+	}
+	if c.TargetHealthyRatio < 0 || c.TargetHealthyRatio > 1 {
+		return fmt.Errorf("target healthy ratio %g must be greater than 0 and at most 1", c.TargetHealthyRatio)
+	}
+	if c.ScheduleS3URI != "" {
+		if len(c.Clusters) > 0 || len(c.RampSchedule) > 0 || c.TargetHealthyRatio != 0 {
+			return errors.New("ScheduleS3URI sets the desired count, so it can't be combined with Clusters, RampSchedule or TargetHealthyRatio")
+		}
+		if _, _, err := parseS3URI(c.ScheduleS3URI); err != nil {
+			return err
+		}
+	}
+	if c.WarmPoolSize > 0 && c.InstanceFlip {
+		return errors.New("WarmPoolSize can't be used in flip mode, which brings the drained capacity back")
+	}
+	switch c.PlanFormat {
+	case "", "text", "tfplan":
+	default:
+		return fmt.Errorf("unknown plan format %q; expected text or tfplan", c.PlanFormat)
+	}
+
+	if len(c.RampSchedule) > 0 {
+		if c.ASGDesiredCount > 0 || c.TargetHealthyRatio != 0 {
+			return errors.New("RampSchedule sets each step's count, so it can't be combined with ASGDesiredCount or TargetHealthyRatio")
+		}
+		for i, step := range c.RampSchedule {
+			if step.DesiredCount <= 0 {
+				return fmt.Errorf("ramp step %d must have a positive count", i+1)
+			}
+			if i > 0 && step.DesiredCount >= c.RampSchedule[i-1].DesiredCount {
+				return fmt.Errorf("ramp step %d to %d doesn't scale down from step %d's %d", i+1, step.DesiredCount, i, c.RampSchedule[i-1].DesiredCount)
+			}
+			if err := c.checkAbsoluteMinInstances(step.DesiredCount); err != nil {
+				return errors.Wrapf(err, "ramp step %d", i+1)
+			}
+		}
+	}
+	return nil
+}
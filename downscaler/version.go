@@ -0,0 +1,19 @@
+package downscaler
+
+import "fmt"
+
+// Build metadata, injected at build time with e.g.
+//
+//	go build -ldflags "-X github.com/maikxchd/ecs-down/downscaler.version=v1.2.0 \
+//	    -X github.com/maikxchd/ecs-down/downscaler.commit=$(git rev-parse --short HEAD) \
+//	    -X github.com/maikxchd/ecs-down/downscaler.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// Version returns the version, git commit and build date of this build.
+func Version() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", version, commit, buildDate)
+}
@@ -0,0 +1,103 @@
+package downscaler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily window of time during which runs are allowed, given as
+// "15:04" times of day. A window that ends before it starts wraps past
+// midnight, e.g. 22:00-06:00.
+type TimeWindow struct {
+	Start string
+	End   string
+}
+
+// ParseTimeWindow parses a window of the form "22:00-06:00".
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: expected START-END, e.g. 22:00-06:00", s)
+	}
+	w := TimeWindow{Start: strings.TrimSpace(parts[0]), End: strings.TrimSpace(parts[1])}
+	if _, _, err := w.minutes(); err != nil {
+		return TimeWindow{}, err
+	}
+	return w, nil
+}
+
+func (w TimeWindow) String() string {
+	return w.Start + "-" + w.End
+}
+
+// Returns the window's start and end as minutes past midnight.
+func (w TimeWindow) minutes() (int, int, error) {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time window start %q: expected HH:MM", w.Start)
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time window end %q: expected HH:MM", w.End)
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// Reports whether t's time of day falls within the window. The start is
+// inclusive and the end exclusive.
+func (w TimeWindow) contains(t time.Time) (bool, error) {
+	start, end, err := w.minutes()
+	if err != nil {
+		return false, err
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return start <= now && now < end, nil
+	}
+	return now >= start || now < end, nil
+}
+
+// Returns the location WindowTimezone names, which allowed windows require.
+func (c *Config) windowLocation() (*time.Location, error) {
+	if c.WindowTimezone == "" {
+		return nil, fmt.Errorf("a timezone is required with allowed windows, e.g. \"UTC\" or \"America/Los_Angeles\"")
+	}
+	loc, err := time.LoadLocation(c.WindowTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window timezone %q: %s", c.WindowTimezone, err)
+	}
+	return loc, nil
+}
+
+// Returns an error unless now falls in one of the allowed windows, evaluated
+// in the configured timezone.
+func (d *DownScaler) checkAllowedWindows(now time.Time) error {
+	if len(d.AllowedWindows) == 0 {
+		return nil
+	}
+	loc, err := d.windowLocation()
+	if err != nil {
+		return err
+	}
+
+	local := now.In(loc)
+	var windows []string
+	for _, w := range d.AllowedWindows {
+		ok, err := w.contains(local)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		windows = append(windows, w.String())
+	}
+
+	outside := fmt.Sprintf("current time %s is outside the allowed windows %s (%s)", local.Format("15:04"), strings.Join(windows, ", "), d.WindowTimezone)
+	if d.ForceOutsideWindow {
+		d.warnf("%s, but running anyway because outside-window runs are forced", outside)
+		return nil
+	}
+	return fmt.Errorf("%s; use -force-outside-window to run anyway", outside)
+}
@@ -1,64 +1,629 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/maikxchd/ecs-down/downscaler"
 )
 
 var (
 	// Required parameters.
-	service      = flag.String("service", "", "The name of the ECS service to scale down.")
-	cluster      = flag.String("cluster", "", "The name of ECS cluster that hosts the service.")
-	asg          = flag.String("asg", "", "The name of the Auto Scaling Group to scale down.")
+	service      = flag.String("service", "", "The name of the ECS service to scale down. Comma-separated to match multiple clusters. Inferred if the cluster runs only one.")
+	cluster      = flag.String("cluster", "", "The name of ECS cluster that hosts the service. Comma-separated to scale down multiple clusters in turn. Inferred from -asg if not given.")
+	asg          = flag.String("asg", "", "The name of the Auto Scaling Group to scale down. Comma-separated to match multiple clusters.")
 	desiredCount = flag.Int64("desired-count", 0, "The number of container instances the ECS cluster should run.")
 
 	// Optional parameters.
 	batchSize    = flag.Int("batch-size", 1, "The number of ECS tasks or container instances to terminate in each batch.")
-	instanceType = flag.String("instance-type", "", `The container instance type that should be preferred for termination.
-If not provided or if there are no instances of this type, all instances are eligible for termination.`)
-	region           = flag.String("region", "us-west-2", "The AWS region containing the resources.")
-	flipMode         = flag.Bool("instance-flip", false, "Flip instances instead of scaling down")
-	sortAge          = flag.Bool("sort-age", false, "Sort instances in each group by instance age")
-	disableTaskCount = flag.Bool("disable-task-count", false, "Disable task count detection")
-	agentVersion     = flag.String("agent-version-before", "", "Prefer killing instances with agent version older than X (exclusive) e.g. '1.39.0'")
-	mismatch         = flag.Bool("allow-mismatch", false, "Advanced: Allow mismatch between containers and instances.")
+	instanceType = flag.String("instance-type", "", `The container instance type that should be preferred for termination, or a comma-separated list of types preferred in that order.
+If not provided or if there are no instances of these types, all instances are eligible for termination.`)
+	asgDesired           = flag.Int64("asg-desired", 0, "The number of instances the ASG should end up with, if different from -desired-count because instances run several tasks each.")
+	region               = flag.String("region", "us-west-2", "The AWS region containing the resources.")
+	flipMode             = flag.Bool("instance-flip", false, "Flip instances instead of scaling down")
+	sortAge              = flag.Bool("sort-age", false, "Sort instances in each group by instance age")
+	disableTaskCount     = flag.Bool("disable-task-count", false, "Disable task count detection")
+	agentVersion         = flag.String("agent-version-before", "", "Prefer killing instances with agent version older than X (exclusive) e.g. '1.39.0'")
+	mismatch             = flag.Bool("allow-mismatch", false, "Advanced: Allow mismatch between containers and instances.")
+	disconnected         = flag.Bool("prefer-disconnected", false, "Prefer killing instances whose ECS agent is disconnected")
+	launchedBefore       = flag.String("launched-before", "", "Prefer killing instances launched before this RFC 3339 time e.g. '2024-01-15T00:00:00Z'")
+	taskDefinition       = flag.String("task-definition", "", "Prefer killing instances running tasks of this task definition (family, family:revision or ARN)")
+	forceDeployment      = flag.Bool("force-new-deployment", false, "Force a new ECS deployment when -instance-flip restores the original task count")
+	drainGrace           = flag.Duration("drain-grace-period", 0, "How long to wait for drained instances to stop their tasks before terminating them")
+	forceAfterGrace      = flag.Bool("force-after-grace", false, "Terminate instances still running tasks once -drain-grace-period expires instead of failing")
+	statePath            = flag.String("state-file", "", "File recording terminated instances so a retried run skips them")
+	parallel             = flag.Bool("parallel-discovery", false, "Run the candidate discovery passes concurrently")
+	deregister           = flag.Bool("deregister", false, "Deregister terminated container instances from the cluster straight away")
+	showProgress         = flag.Bool("progress", false, "Print a progress bar every time the run changes phase")
+	dryRun               = flag.Bool("dry-run", false, "Print the batches that would be drained and an estimated duration without changing anything")
+	respectSpread        = flag.Bool("respect-spread", false, "Abort before draining a batch that would remove all of the service's tasks from an AZ it spreads over")
+	healthCheckURL       = flag.String("health-check-url", "", "A URL to GET between batches; the run aborts if it keeps failing")
+	healthFailures       = flag.Int("health-check-failures", 3, "How many consecutive -health-check-url failures abort the run")
+	healthLatency        = flag.Duration("health-check-max-latency", 0, "Treat -health-check-url responses slower than this as failures")
+	allowedWindows       = flag.String("allowed-windows", "", "Comma-separated daily windows the run is allowed in e.g. '22:00-06:00'. Requires -window-timezone")
+	windowTimezone       = flag.String("window-timezone", "", "The timezone -allowed-windows are given in e.g. 'UTC' or 'America/Los_Angeles'")
+	outsideWindow        = flag.Bool("force-outside-window", false, "Run even if the current time is outside -allowed-windows")
+	printVersion         = flag.Bool("version", false, "Print the version and exit")
+	suspendProcesses     = flag.String("suspend-processes", "", "Comma-separated ASG scaling processes to suspend during the run e.g. 'Launch,AZRebalance'")
+	minPerAZ             = flag.Int("min-per-az", 0, "The number of instances every availability zone must keep")
+	drainDelay           = flag.Duration("pause-between-drain-and-terminate", 0, "A fixed pause between draining a batch and terminating it; 0 pauses for the task definition's longest stopTimeout")
+	instanceWeights      = flag.String("instance-weights", "", "Comma-separated capacity weights of a weighted mixed instances ASG e.g. 'm5.large=1,m5.2xlarge=4'")
+	failIfNoChange       = flag.Bool("fail-if-no-change", false, "Fail if the cluster is already at the desired size instead of exiting successfully")
+	stopReason           = flag.String("stop-reason", "", "The reason given when stopping tasks still running on drained instances before termination")
+	suspendTarget        = flag.Bool("suspend-scalable-target", false, "Suspend the service's Application Auto Scaling activities during the run instead of refusing to run")
+	deregisterTarget     = flag.Bool("deregister-scalable-target", false, "An alias for -suspend-scalable-target; the target is suspended, not deregistered")
+	skipCapacity         = flag.Bool("skip-capacity-check", false, "Skip checking that the remaining instances can fit the desired tasks")
+	skipConstraints      = flag.Bool("skip-constraint-check", false, "Only warn when the remaining instances no longer match the service's memberOf placement constraints")
+	continueOnError      = flag.Bool("continue-on-error", false, "Carry on with the rest of a batch when some of its instances fail to drain")
+	maxCurrentTasks      = flag.Int64("max-current-tasks", 0, "Refuse to scale down while the service is running more tasks than this")
+	reevaluate           = flag.Bool("reevaluate-each-batch", false, "Find the best instances to drain again before every batch")
+	useFIPS              = flag.Bool("use-fips-endpoints", false, "Send all AWS requests to FIPS endpoints, failing if a service has none in the region")
+	failOnWarning        = flag.Bool("fail-on-warning", false, "Exit with an error if the run logged any warnings")
+	preferUnhealthy      = flag.Bool("prefer-unhealthy", false, "Prefer draining instances hosting load balancer targets that fail health checks")
+	maxRetries           = flag.Int("aws-max-retries", -1, "How many times to retry failed AWS requests; -1 keeps the SDK default")
+	terminateTimeout     = flag.Duration("terminate-timeout", 0, "How long to wait for terminated instances to shut down before failing; 0 uses the SDK waiter's limit")
+	decrementOnTerminate = flag.Bool("decrement-on-terminate", false, "Decrement the ASG desired capacity with each termination instead of lowering it beforehand")
+	printSchema          = flag.Bool("print-schema", false, "Print the JSON Schema of run results and exit")
+	capacityProvider     = flag.String("capacity-provider", "", "Only drain instances managed by this capacity provider, whose ASG must be -asg")
+	record               = flag.Bool("record", false, "Run without changing anything, printing every AWS request that would make a change")
+	controlFile          = flag.String("control-file", "", "File checked between batches for pause, resume or abort")
+	waitForReschedule    = flag.Bool("wait-for-reschedule", false, "After scaling the service, wait until all its tasks are running and its targets healthy")
+	rescheduleTimeout    = flag.Duration("wait-for-reschedule-timeout", 15*time.Minute, "How long -wait-for-reschedule waits before failing")
+	planFormat           = flag.String("output", "text", "How -dry-run prints its plan: text or tfplan")
+	protectNewest        = flag.Int("protect-newest", 0, "Never drain this many of the most recently launched instances")
+	finalShrinkDelay     = flag.Duration("final-shrink-delay", 0, "How long to wait before setting the ASG's final sizes, as a last chance to abort")
+	minReplicas          = flag.Int64("min-service-replicas", 0, "Limit batches so draining never takes the service below this many tasks")
+	terminateOrphans     = flag.Bool("terminate-orphans", false, "Terminate drained instances that aren't in the ASG through EC2 instead of failing")
+	waitBeforeShrink     = flag.Bool("wait-before-asg-shrink", false, "Wait for drained instances to stop all their tasks before shrinking the ASG")
+	shrinkWaitTimeout    = flag.Duration("wait-before-asg-shrink-timeout", 15*time.Minute, "How long -wait-before-asg-shrink waits before failing, or shrinking anyway with -force-after-grace")
+	eventBus             = flag.String("eventbridge-bus", "", "EventBridge event bus to put progress events on")
+	stableRetries        = flag.Int("stable-wait-retries", 0, "How many more times to wait for the service to become stable when waiting times out")
+	lifo                 = flag.Bool("lifo", false, "Sort instances in each group newest first; can't be combined with -sort-age")
+	minHealthyAfter      = flag.Int64("min-healthy-after", 0, "Once done, wait until at least this many container instances are ACTIVE and connected")
+	minHealthyTimeout    = flag.Duration("min-healthy-timeout", 10*time.Minute, "How long -min-healthy-after waits before failing; 0 waits forever")
+	maxConcurrentDrain   = flag.Int("max-concurrent-draining", 0, "Never have more than this many container instances in the cluster draining at once")
+	explain              = flag.Bool("explain", false, "Log each step of selecting the instances to drain, and why each was chosen")
+	healthyRatio         = flag.Float64("target-healthy-ratio", 0, "Scale down to this fraction of the service's running tasks, rounded up, instead of -desired-count")
+	tagBeforeTerminate   = flag.String("tag-before-terminate", "", "Comma-separated EC2 tags put on instances just before terminating them e.g. 'terminated-by=ecs-down,terminated-at={now}'")
+	attributeExpiry      = flag.String("attribute-expiry-key", "", "Prefer draining instances whose value of this attribute, an RFC 3339 time, has passed")
+	maxBatchDuration     = flag.Duration("max-batch-duration", 0, "Fail the run if a batch takes longer than this")
+	rampSchedule         = flag.String("ramp-schedule", "", "Scale down in steps instead of to -desired-count, holding at each while watching health e.g. '15=10m,10=10m,5'")
+	checkDrift           = flag.Bool("check-drift", false, "Only compare the current sizes with the targets, exiting with code 3 if they differ")
+	requireTagAbsent     = flag.String("require-tag-absent", "", "Comma-separated EC2 tag keys; instances with any of them are never drained")
+	junitReport          = flag.String("junit-report", "", "Write a JUnit XML report of the run to this file, with a test case per batch")
+	verifyASGSettle      = flag.Duration("verify-asg-settle", 0, "Once done, check the ASG kept the sizes it was set to, allowing this long for them to settle")
+	sqsQueueURL          = flag.String("sqs-queue-url", "", "Before terminating drained instances, wait for this queue's in-flight messages to be processed")
+	sqsMaxInFlight       = flag.Int64("sqs-max-in-flight", 0, "How many in-flight messages -sqs-queue-url may still have when terminating")
+	sqsSettleTimeout     = flag.Duration("sqs-settle-timeout", 10*time.Minute, "How long to wait for -sqs-queue-url's in-flight messages before failing; 0 waits forever")
+	cordonList           = flag.String("cordon-list", "", "File of EC2 instance IDs or container instance ARNs to drain first, updated as they are terminated")
+	skipStableWait       = flag.Bool("skip-stable-wait", false, "Don't wait for the service, ASG or terminated instances to settle after each change; for throwaway clusters")
+	maxReservation       = flag.Float64("max-reservation-percent", 0, "Prefer draining instances with less than this percentage of their memory reserved")
+	delayStartFlag       = flag.String("delay-start", "", "Wait this long, e.g. '8h', or until this RFC 3339 time before starting")
+	warnInstanceStore    = flag.Bool("warn-on-instance-store", false, "Warn before terminating instances with instance store volumes")
+	historyTable         = flag.String("history-table", "", "DynamoDB table, with a runId string hash key, to record every run in")
+	preflightIAMCheck    = flag.Bool("preflight-iam-check", false, "Before starting, simulate the caller's IAM policies and fail if any permission the run needs is missing")
+	scoreWeights         = flag.String("score-weights", "", "Order candidates by a weighted score instead of the built-in priorities: 'default' or e.g. 'age=1,taskCount=2,reservation=4,agentVersion=8'")
+	confirmEach          = flag.Bool("drain-confirmation-per-instance", false, "Ask before draining each instance: y drains it, n skips it for another, abort stops the run. Needs a terminal, or -yes")
+	yes                  = flag.Bool("yes", false, "Approve every confirmation without asking, e.g. when stdin isn't a terminal")
+	waitForASGSettle     = flag.Duration("wait-for-asg-settle", 0, "Before changing anything, wait this long for the ASG's scaling activities in progress to finish; 0 fails straight away if there are any")
+	csvReport            = flag.String("csv-report", "", "Write the drain order to this CSV file, with each instance's details and whether it was terminated; dry runs too")
+	absoluteMin          = flag.Int64("absolute-min-instances", 0, "Refuse to run if it would leave the cluster with fewer instances than this, whatever the desired count")
+	preferFailedTasks    = flag.Bool("prefer-failed-task-hosts", false, "Prefer draining instances where tasks recently failed, e.g. crash looping or running out of memory")
+	failedTaskLookback   = flag.Duration("failed-task-lookback", time.Hour, "How far back -prefer-failed-task-hosts looks for failed tasks")
+	cwLogGroup           = flag.String("cloudwatch-log-group", "", "Also send the run's log to this CloudWatch Logs log group")
+	cwLogStream          = flag.String("cloudwatch-log-stream", "", "The log stream for -cloudwatch-log-group; by default one named after the run's start time")
+	warmPoolSize         = flag.Int("warm-pool-size", 0, "Keep up to this many drained instances running, still DRAINING, instead of terminating them")
+	scheduleS3URI        = flag.String("schedule-s3-uri", "", "Read the desired count for the current time from a JSON schedule in S3, e.g. 's3://capacity/visage-prod.json'; -desired-count is the fallback")
+	overrideKillSwitch   = flag.Bool("override-kill-switch", false, "Run even if the cluster carries the kill-switch tag ecs-down:disabled=true")
+	stepByDensity        = flag.Bool("step-by-task-density", false, "Lower the service's task count with each batch by the tasks actually running on its instances, for services running several tasks per instance")
+	reason               = flag.String("reason", "", "Why the run happens, e.g. 'cost savings Q3'; added to the logs, events and history, and to -stop-reason and -tag-before-terminate when those are set")
+	requireReason        = flag.Bool("require-reason", false, "Refuse to run without -reason")
+	preferRebalance      = flag.Bool("prefer-rebalance-recommended", false, "Prefer draining spot instances EC2 has marked for interruption, since they're going away anyway")
+	checkConfig          = flag.Bool("check-config", false, "Only check that the flags and environment make a valid configuration, printing it, without any AWS calls")
 )
 
+// The exit code of -check-drift when the cluster has drifted, so monitoring
+// can tell drift from failing to check.
+const driftExitCode = 3
+
 func main() {
 	flag.Parse()
+	fromEnv, err := applyEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 	//	log.SetFlags(0)
 
-	if *service == "" {
-		log.Fatal("Missing required argument: service")
+	if *printVersion {
+		fmt.Println("ecs-down", downscaler.Version())
+		return
 	}
-	if *cluster == "" {
-		log.Fatal("Missing required argument: cluster")
+	if *printSchema {
+		fmt.Print(downscaler.JSONSchema())
+		return
 	}
+
+	// The cluster and service can be inferred from the ASG.
 	if *asg == "" {
 		log.Fatal("Missing required argument: asg")
 	}
-	if *desiredCount <= 0 {
+	if *desiredCount <= 0 && *healthyRatio == 0 && *rampSchedule == "" && *scheduleS3URI == "" {
 		log.Fatal("desired-count must be a positive integer")
 	}
+	if *asgDesired < 0 {
+		log.Fatal("asg-desired must be a positive integer")
+	}
+
+	var processes []string
+	if *suspendProcesses != "" {
+		processes = strings.Split(*suspendProcesses, ",")
+	}
+
+	var delayStart time.Time
+	if *delayStartFlag != "" {
+		if delay, err := time.ParseDuration(*delayStartFlag); err == nil {
+			delayStart = time.Now().Add(delay)
+		} else if delayStart, err = time.Parse(time.RFC3339, *delayStartFlag); err != nil {
+			log.Fatalf("Invalid -delay-start %q: expected a duration or an RFC 3339 time", *delayStartFlag)
+		}
+	}
+
+	var launchCutoff time.Time
+	if *launchedBefore != "" {
+		t, err := time.Parse(time.RFC3339, *launchedBefore)
+		if err != nil {
+			log.Fatalf("Invalid -launched-before: %s", err)
+		}
+		launchCutoff = t
+	}
+
+	var windows []downscaler.TimeWindow
+	if *allowedWindows != "" {
+		for _, w := range strings.Split(*allowedWindows, ",") {
+			window, err := downscaler.ParseTimeWindow(w)
+			if err != nil {
+				log.Fatal(err)
+			}
+			windows = append(windows, window)
+		}
+	}
+
+	weights, err := parseInstanceWeights(*instanceWeights)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	terminateTags, err := parseTags(*tagBeforeTerminate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var lockTags []string
+	if *requireTagAbsent != "" {
+		lockTags = strings.Split(*requireTagAbsent, ",")
+	}
+
+	score, err := parseScoreWeights(*scoreWeights)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var instanceTypes []string
+	if *instanceType != "" {
+		instanceTypes = strings.Split(*instanceType, ",")
+	}
+
+	ramp, err := parseRampSchedule(*rampSchedule)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clusters, err := clusterSpecs(*cluster, *service, *asg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var progressFunc func(downscaler.Progress)
+	if *showProgress {
+		progressFunc = printProgress
+	}
+
+	var confirmInstance func(*downscaler.CandidateInstance) (downscaler.Confirmation, error)
+	if *confirmEach && !*yes {
+		if !stdinIsTerminal() {
+			log.Fatal("-drain-confirmation-per-instance needs a terminal to ask on; pass -yes to approve every instance")
+		}
+		confirmInstance = newInstancePrompt()
+	}
 
-	d := downscaler.New(&downscaler.Config{
-		Service:      *service,
-		Cluster:      *cluster,
-		ASG:          *asg,
-		DesiredCount: *desiredCount,
-		BatchSize:    *batchSize,
-		InstanceType: *instanceType,
-		Region:       *region,
+	var awsMaxRetries *int
+	if *maxRetries >= 0 {
+		awsMaxRetries = maxRetries
+	}
+
+	config := &downscaler.Config{
+		Service:         *service,
+		Cluster:         *cluster,
+		ASG:             *asg,
+		DesiredCount:    *desiredCount,
+		ASGDesiredCount: *asgDesired,
+		BatchSize:       *batchSize,
+		InstanceTypes:   instanceTypes,
+		Region:          *region,
 
 		InstanceFlip:          *flipMode,
 		SortByAge:             *sortAge,
 		AllowASGMismatch:      *mismatch,
 		TaskCountDetect:       !*disableTaskCount,
 		AgentVersionThreshold: *agentVersion,
-	})
-	if err := d.Run(); err != nil {
+
+		PreferDisconnectedAgents:     *disconnected,
+		SuspendASGProcesses:          processes,
+		TaskDefinitionFilter:         *taskDefinition,
+		ForceNewDeployment:           *forceDeployment,
+		StatePath:                    *statePath,
+		DrainGracePeriod:             *drainGrace,
+		ForceAfterGrace:              *forceAfterGrace,
+		Clusters:                     clusters,
+		ParallelDiscovery:            *parallel,
+		DryRun:                       *dryRun,
+		ProgressFunc:                 progressFunc,
+		DeregisterContainerInstances: *deregister,
+		RespectSpread:                *respectSpread,
+		HealthCheckURL:               *healthCheckURL,
+		HealthCheckFailures:          *healthFailures,
+		HealthCheckMaxLatency:        *healthLatency,
+		AllowedWindows:               windows,
+		WindowTimezone:               *windowTimezone,
+		ForceOutsideWindow:           *outsideWindow,
+		LaunchedBefore:               launchCutoff,
+		MinPerAZ:                     *minPerAZ,
+		DrainToTerminateDelay:        *drainDelay,
+		InstanceWeights:              weights,
+		FailIfNoChange:               *failIfNoChange,
+		StopReason:                   *stopReason,
+		SuspendScalableTarget:        *suspendTarget,
+		DeregisterScalableTarget:     *deregisterTarget,
+		SkipCapacityCheck:            *skipCapacity,
+		SkipConstraintCheck:          *skipConstraints,
+		ContinueOnError:              *continueOnError,
+		MaxCurrentTasks:              *maxCurrentTasks,
+		ReevaluateEachBatch:          *reevaluate,
+		UseFIPSEndpoints:             *useFIPS,
+		FailOnWarning:                *failOnWarning,
+		PreferUnhealthy:              *preferUnhealthy,
+		AWSMaxRetries:                awsMaxRetries,
+		TerminateTimeout:             *terminateTimeout,
+		DecrementOnTerminate:         *decrementOnTerminate,
+		CapacityProviderFilter:       *capacityProvider,
+		Record:                       *record,
+		ControlFilePath:              *controlFile,
+		WaitForReschedule:            *waitForReschedule,
+		WaitForRescheduleTimeout:     *rescheduleTimeout,
+		PlanFormat:                   *planFormat,
+		ProtectNewest:                *protectNewest,
+		FinalShrinkDelay:             *finalShrinkDelay,
+		MinServiceReplicas:           *minReplicas,
+		TerminateOrphansWithEC2:      *terminateOrphans,
+		WaitBeforeASGShrink:          *waitBeforeShrink,
+		WaitBeforeASGShrinkTimeout:   *shrinkWaitTimeout,
+		EventBridgeBus:               *eventBus,
+		StableWaitRetries:            *stableRetries,
+		LIFO:                         *lifo,
+		MinHealthyAfter:              *minHealthyAfter,
+		MinHealthyAfterTimeout:       *minHealthyTimeout,
+		MaxConcurrentDraining:        *maxConcurrentDrain,
+		Explain:                      *explain,
+		TargetHealthyRatio:           *healthyRatio,
+		TagBeforeTerminate:           terminateTags,
+		AttributeExpiryKey:           *attributeExpiry,
+		MaxBatchDuration:             *maxBatchDuration,
+		RampSchedule:                 ramp,
+		RequireTagAbsent:             lockTags,
+		JUnitReportPath:              *junitReport,
+		VerifyASGSettle:              *verifyASGSettle,
+		SQSQueueURL:                  *sqsQueueURL,
+		SQSMaxInFlight:               *sqsMaxInFlight,
+		SQSSettleTimeout:             *sqsSettleTimeout,
+		CordonListPath:               *cordonList,
+		SkipStableWait:               *skipStableWait,
+		MaxReservationPercent:        *maxReservation,
+		DelayStart:                   delayStart,
+		WarnOnInstanceStore:          *warnInstanceStore,
+		HistoryTable:                 *historyTable,
+		PreflightIAMCheck:            *preflightIAMCheck,
+		ScoreWeights:                 score,
+		ConfirmInstance:              confirmInstance,
+		WaitForASGSettle:             *waitForASGSettle,
+		CSVReportPath:                *csvReport,
+		AbsoluteMinInstances:         *absoluteMin,
+		PreferFailedTaskHosts:        *preferFailedTasks,
+		FailedTaskLookback:           *failedTaskLookback,
+		CloudWatchLogGroup:           *cwLogGroup,
+		CloudWatchLogStream:          *cwLogStream,
+		WarmPoolSize:                 *warmPoolSize,
+		ScheduleS3URI:                *scheduleS3URI,
+		OverrideKillSwitch:           *overrideKillSwitch,
+		StepByTaskDensity:            *stepByDensity,
+		Reason:                       *reason,
+		RequireReason:                *requireReason,
+		PreferRebalanceRecommended:   *preferRebalance,
+	}
+	if *checkConfig {
+		printEffectiveConfig(fromEnv)
+		if err := config.Validate(); err != nil {
+			log.Fatalf("Invalid configuration: %s", err)
+		}
+		fmt.Println("Configuration is valid")
+		return
+	}
+	d := downscaler.New(config)
+	if *checkDrift {
+		drift, err := d.CheckDrift()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !drift.Drifted() {
+			fmt.Println("No drift")
+			return
+		}
+		fmt.Printf("Drifted:\n\t%s\n", strings.Join(drift.Differences, "\n\t"))
+		os.Exit(driftExitCode)
+	}
+	result, err := d.RunWithContext(interruptible())
+	if result != nil {
+		for _, i := range result.Instances {
+			log.Printf("Terminated %s (%s, %s, launched %s): %s", i.EC2InstanceID, i.InstanceType, i.AvailabilityZone, i.LaunchTime.Format(time.RFC3339), i.Reason)
+		}
+		for _, spec := range clusters {
+			if r, ok := result.Clusters[spec.Cluster]; ok {
+				log.Printf("%s: terminated %d instances", spec.Cluster, len(r.Terminated))
+			}
+		}
+		if len(result.ForceTerminated) > 0 {
+			log.Printf("Force-terminated %d instances still running tasks: %s", len(result.ForceTerminated), strings.Join(result.ForceTerminated, ", "))
+		}
+		if len(result.Warnings) > 0 {
+			log.Printf("%d warnings:\n\t%s", len(result.Warnings), strings.Join(result.Warnings, "\n\t"))
+		}
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// Returns a context cancelled on the first SIGINT or SIGTERM, so the run stops
+// and resumes what it suspended. A second signal kills the process as usual.
+func interruptible() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("Received %s; stopping after resuming suspended scaling. Send it again to exit now.", sig)
+		cancel()
+	}()
+	return ctx
+}
+
+// Splits comma-separated -cluster, -service and -asg values into one spec per
+// cluster. A single cluster returns no specs, leaving the plain fields in use.
+func clusterSpecs(clusters, services, asgs string) ([]downscaler.ClusterSpec, error) {
+	if !strings.Contains(clusters, ",") && !strings.Contains(services, ",") && !strings.Contains(asgs, ",") {
+		return nil, nil
+	}
+
+	c, s, a := strings.Split(clusters, ","), strings.Split(services, ","), strings.Split(asgs, ",")
+	if len(c) != len(s) || len(c) != len(a) {
+		return nil, fmt.Errorf("-cluster, -service and -asg must list the same number of values; got %d, %d and %d", len(c), len(s), len(a))
+	}
+
+	specs := make([]downscaler.ClusterSpec, 0, len(c))
+	for i := range c {
+		specs = append(specs, downscaler.ClusterSpec{Cluster: c[i], Service: s[i], ASG: a[i]})
+	}
+	return specs, nil
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Returns a ConfirmInstance that prints each instance's details and asks on
+// the terminal whether to drain it.
+func newInstancePrompt() func(*downscaler.CandidateInstance) (downscaler.Confirmation, error) {
+	in := bufio.NewReader(os.Stdin)
+	return func(c *downscaler.CandidateInstance) (downscaler.Confirmation, error) {
+		fmt.Printf("About to drain %s\n", c.ContainerInstanceArn)
+		fmt.Printf("\tEC2 instance: %s (%s, %s)\n", c.EC2InstanceID, c.InstanceType, c.AvailabilityZone)
+		fmt.Printf("\tAge:          %s (launched %s)\n", time.Since(c.LaunchTime).Round(time.Minute), c.LaunchTime.Format(time.RFC3339))
+		fmt.Printf("\tTasks:        %d\n", c.RunningTasksCount)
+		for {
+			fmt.Print("Drain it? [y/n/abort] ")
+			answer, err := in.ReadString('\n')
+			if err != nil {
+				return downscaler.ConfirmAbort, err
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "y", "yes":
+				return downscaler.ConfirmDrain, nil
+			case "n", "no":
+				return downscaler.ConfirmSkip, nil
+			case "abort":
+				return downscaler.ConfirmAbort, nil
+			}
+		}
+	}
+}
+
+// Prints a progress bar of the batches completed so far.
+func printProgress(p downscaler.Progress) {
+	const width = 30
+	done := 0
+	if p.TotalBatches > 0 {
+		completed := p.Batch - 1
+		if p.Phase == downscaler.PhaseFinalizing || p.Phase == downscaler.PhaseDone {
+			completed = p.TotalBatches
+		}
+		done = width * completed / p.TotalBatches
+	}
+	bar := strings.Repeat("#", done) + strings.Repeat("-", width-done)
+	fmt.Fprintf(os.Stderr, "[%s] batch %d/%d, %d drained, %d terminated (%s)\n", bar, p.Batch, p.TotalBatches, p.Drained, p.Terminated, p.Phase)
+}
+
+// Parses -instance-weights values of the form "m5.large=1,m5.2xlarge=4".
+func parseInstanceWeights(value string) (map[string]int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	weights := make(map[string]int64)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid instance weight %q: expected TYPE=WEIGHT", pair)
+		}
+		weight, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid instance weight %q: weight must be a positive integer", pair)
+		}
+		weights[parts[0]] = weight
+	}
+	return weights, nil
+}
+
+// Parses -tag-before-terminate values of the form "terminated-by=ecs-down,terminated-at={now}".
+func parseTags(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag %q: expected KEY=VALUE", pair)
+		}
+		tags[parts[0]] = parts[1]
+	}
+	return tags, nil
+}
+
+// Parses -score-weights values of the form
+// "age=1,taskCount=2,reservation=4,agentVersion=8", or "default". Factors
+// left out weigh nothing.
+func parseScoreWeights(value string) (*downscaler.ScoreWeights, error) {
+	switch value {
+	case "":
+		return nil, nil
+	case "default":
+		weights := downscaler.DefaultScoreWeights
+		return &weights, nil
+	}
+	weights := &downscaler.ScoreWeights{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid score weight %q: expected FACTOR=WEIGHT", pair)
+		}
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid score weight %q: weight must be a non-negative number", pair)
+		}
+		switch parts[0] {
+		case "age":
+			weights.Age = weight
+		case "taskCount":
+			weights.TaskCount = weight
+		case "reservation":
+			weights.Reservation = weight
+		case "agentVersion":
+			weights.AgentVersion = weight
+		default:
+			return nil, fmt.Errorf("invalid score weight %q: factor must be age, taskCount, reservation or agentVersion", pair)
+		}
+	}
+	return weights, nil
+}
+
+// Parses -ramp-schedule values of the form "15=10m,10=10m,5", each a count
+// and how long to hold at it.
+func parseRampSchedule(value string) ([]downscaler.RampStep, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var steps []downscaler.RampStep
+	for _, step := range strings.Split(value, ",") {
+		parts := strings.SplitN(step, "=", 2)
+		count, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid ramp step %q: count must be a positive integer", step)
+		}
+		var hold time.Duration
+		if len(parts) == 2 {
+			if hold, err = time.ParseDuration(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid ramp step %q: %s", step, err)
+			}
+		}
+		steps = append(steps, downscaler.RampStep{DesiredCount: count, Hold: hold})
+	}
+	return steps, nil
+}
+
+// Sets every flag not given on the command line from its ECS_DOWN_ environment
+// variable, if set. -desired-count is read from ECS_DOWN_DESIRED_COUNT, for
+// example. Flags given on the command line take precedence. Returns the
+// environment variable each flag was set from, by flag name.
+func applyEnv() (map[string]string, error) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	fromEnv := make(map[string]string)
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := envVarName(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if setErr := flag.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %s", value, name, setErr)
+			}
+			fromEnv[f.Name] = name
+		}
+	})
+	return fromEnv, err
+}
+
+// Prints every flag's value, and where it came from: the command line, its
+// environment variable or its default.
+func printEffectiveConfig(fromEnv map[string]string) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		source := "default"
+		if name, ok := fromEnv[f.Name]; ok {
+			source = name
+		} else if set[f.Name] {
+			source = "command line"
+		}
+		fmt.Printf("-%s=%s\t(%s)\n", f.Name, f.Value, source)
+	})
+}
+
+func envVarName(flagName string) string {
+	return "ECS_DOWN_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}